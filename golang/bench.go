@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"protohackers/utils"
+	"protohackers/utils/bench"
+	"strconv"
+)
+
+// runBench implements -bench: every selected problem is loaded with simulated clients in turn,
+// either at target or, if that is empty, at an instance started in this process with cfg and
+// settings. It returns the exit code.
+func runBench(selected []utils.Registered, cfg utils.Config, settings *utils.ConfigFile, target string, opts bench.Options) int {
+	if target == "" && utils.IsPathAddress(cfg.Host) {
+		fmt.Println("-bench needs a host:port to listen on, or -bench-addr")
+		return 1
+	}
+	ctx := context.Background()
+	failed := false
+	for _, r := range selected {
+		if !bench.Available(r.Service.Name()) {
+			fmt.Printf("%s: no benchmark scenario, skipped\n", r.Service.Name())
+			continue
+		}
+		opts.Addr = target
+		if target == "" {
+			pcfg := problemConfig(cfg, settings, r, len(selected) > 1)
+			if err := r.Service.Start(ctx, pcfg); err != nil {
+				fmt.Printf("cannot start %s: %v\n", r.Service.Name(), err)
+				return 1
+			}
+			opts.Addr = net.JoinHostPort(dialHost(pcfg.Host), strconv.Itoa(pcfg.Port))
+		}
+		res, err := bench.Run(ctx, r.Service.Name(), opts)
+		if target == "" {
+			r.Service.Stop(ctx)
+		}
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+		res.Write(os.Stdout)
+		if res.ErrorCount() > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// dialHost returns the address clients reach a server listening on host at.
+func dialHost(host string) string {
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return "127.0.0.1"
+	}
+	return host
+}
@@ -1,3 +1,3 @@
 module protohackers
 
-go 1.19
\ No newline at end of file
+go 1.21
\ No newline at end of file
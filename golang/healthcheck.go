@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"strconv"
+	"time"
+)
+
+// runHealthcheck implements "healthcheck [-addr host:port] problem": the problem's health check,
+// e.g. an echo round trip or a version query, is run against its server. By default the server is
+// where PROTOHACKERS_LISTEN and PROTOHACKERS_PORT have it listen, so a container can check itself.
+// It returns the exit code.
+func runHealthcheck(args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s healthcheck [flags] problem\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "", "the server to check, e.g. 127.0.0.1:10000; by default where PROTOHACKERS_LISTEN and PROTOHACKERS_PORT have it listen")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	r, err := utils.LookupService(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	target := *addr
+	if target == "" {
+		cfg := utils.DefaultConfig()
+		host := envOr("PROTOHACKERS_LISTEN", cfg.Host)
+		if utils.IsPathAddress(host) {
+			fmt.Println("healthcheck needs a host:port to check, or -addr")
+			return 2
+		}
+		target = net.JoinHostPort(dialHost(host), strconv.Itoa(envIntOr("PROTOHACKERS_PORT", cfg.Port)))
+	}
+
+	start := time.Now()
+	if err := conformance.Check(r.Service.Name(), target); err != nil {
+		fmt.Printf("FAIL %s at %s: %v\n", r.Service.Name(), target, err)
+		return 1
+	}
+	fmt.Printf("ok   %s at %s (%v)\n", r.Service.Name(), target, time.Since(start).Round(time.Millisecond))
+	return 0
+}
@@ -1,35 +1,336 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
-	"protohackers/problem00"
-	"protohackers/problem01"
-	"protohackers/problem02"
-	"protohackers/problem03"
-	"protohackers/problem04"
-	"protohackers/problem05"
-	"protohackers/problem06"
+	"os/signal"
+	_ "protohackers/problem00"
+	_ "protohackers/problem01"
+	_ "protohackers/problem02"
+	_ "protohackers/problem03"
+	_ "protohackers/problem04"
+	_ "protohackers/problem05"
+	_ "protohackers/problem06"
+	"protohackers/utils"
+	"protohackers/utils/admin"
+	"protohackers/utils/bench"
+	"protohackers/utils/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
-	problems := []func(){
-		problem00.Run,
-		problem01.Run,
-		problem02.Run,
-		problem03.Run,
-		problem04.Run,
-		problem05.Run,
-		problem06.Run,
-	}
-	problem := flag.Int("problem", -1, "the problem to run")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "healthcheck":
+			os.Exit(runHealthcheck(os.Args[2:]))
+		}
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s replay [flags] recording...\n       %s healthcheck [flags] problem\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	cfg := utils.DefaultConfig()
+	problemList := flag.String("problem", "", "comma separated list of problem numbers or names to run, e.g. 0,prime,3,speed")
+	all := flag.Bool("all", false, "run every problem")
+	list := flag.Bool("list", false, "list the available problems and exit")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second, "how long to wait for open connections to finish on shutdown")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Minute, "after a restart (SIGHUP or SIGUSR2), how long the old process keeps serving its open connections")
+	restartTimeout := flag.Duration("restart-timeout", 30*time.Second, "how long a restart waits for the new process to start serving")
+	flag.StringVar(&cfg.Host, "listen", envOr("PROTOHACKERS_LISTEN", cfg.Host), "the address to listen on, or unix:/path, fd:N, systemd[:name]; {name} is replaced by the problem name (env PROTOHACKERS_LISTEN)")
+	flag.IntVar(&cfg.Port, "port", envIntOr("PROTOHACKERS_PORT", cfg.Port), "the port to listen on; when running several problems, problem N listens on port+N (env PROTOHACKERS_PORT)")
+	flag.IntVar(&cfg.MaxConns, "max-conns", 0, "maximum number of connections served at once per problem, 0 for unlimited")
+	flag.IntVar(&cfg.MaxConnsPerIP, "max-conns-per-ip", 0, "maximum number of connections per remote IP, 0 for unlimited")
+	flag.DurationVar(&cfg.LimitQueueTimeout, "limit-queue-timeout", 0, "how long a connection over a limit waits for a free slot, 0 rejects it immediately")
+	flag.StringVar(&cfg.LimitBanner, "limit-banner", "", "message sent to connections rejected by a limit")
+	flag.DurationVar(&cfg.IdleTimeout, "idle-timeout", 0, "close connections idle for this long, 0 to disable")
+	flag.DurationVar(&cfg.ReadTimeout, "read-timeout", 0, "fail reads blocking for longer than this, 0 to disable")
+	flag.DurationVar(&cfg.WriteTimeout, "write-timeout", 0, "fail writes blocking for longer than this, 0 to disable")
+	flag.IntVar(&cfg.UDPWorkers, "udp-workers", 0, "number of datagrams handled concurrently by UDP problems, 0 for one per CPU")
+	tlsCert := flag.String("tls-cert", "", "serve TCP problems over TLS with this PEM certificate file")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	tlsSelfSigned := flag.Bool("tls-self-signed", false, "serve TCP problems over TLS with a generated self-signed certificate, for development")
+	tlsClientCA := flag.String("tls-client-ca", "", "verify client certificates against the CAs in this PEM file; problem 6 then requires one from dispatchers")
+	proxyProtocol := flag.String("proxy-protocol", "off", "expect PROXY protocol v1/v2 headers on TCP connections: off, optional or strict (reject connections without one)")
+	flag.StringVar(&cfg.RecordDir, "record-dir", "", "record every TCP session to a file in this directory, for the replay subcommand")
+	flag.BoolVar(&cfg.Trace, "trace", false, "log every protocol frame in hex, decoded the way the problem statements show them")
+	configFile := flag.String("config", envOr("PROTOHACKERS_CONFIG", ""), "read the problems' settings from this TOML file, a [name] section per problem; PROTOHACKERS_SET_<NAME>_<KEY> environment variables override them (env PROTOHACKERS_CONFIG)")
+	adminAddr := flag.String("admin-addr", "", "serve the admin HTTP API on this address, e.g. :9101; without a host it only listens on 127.0.0.1, as the API is unauthenticated and lets anyone reaching it list and kick connections")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics over HTTP on this address, e.g. :9100")
+	logLevel := flag.String("log-level", "info", "minimum level to log: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	benchMode := flag.Bool("bench", false, "load the selected problems with simulated clients and report throughput, latency and errors instead of serving")
+	benchAddr := flag.String("bench-addr", "", "with -bench, the server to load, e.g. 127.0.0.1:10000; by default the problem is started in this process")
+	benchClients := flag.Int("bench-clients", 10, "with -bench, the number of simulated clients running at once")
+	benchDuration := flag.Duration("bench-duration", 10*time.Second, "with -bench, how long to load each problem")
+	selftest := flag.String("selftest", "", "run the conformance suites of these problems (like -problem) against running servers and exit")
+	selftestAddr := flag.String("selftest-addr", "", "with -selftest, the server to test, e.g. 127.0.0.1:10000; by default where -listen and -port would serve the problem")
 	flag.Parse()
-	if *problem < 0 || *problem > (len(problems)-1) {
-		fmt.Println("You want problem = ", *problem)
-		fmt.Println("Please specify a problem between 0 and", len(problems)-1)
+
+	if err := utils.SetupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	tlsConfig, err := utils.LoadTLSConfig(*tlsCert, *tlsKey, *tlsClientCA, *tlsSelfSigned)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	cfg.TLS = tlsConfig
+	if cfg.ProxyProtocol, err = utils.ParseProxyMode(*proxyProtocol); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if cfg.RecordDir != "" {
+		if err := os.MkdirAll(cfg.RecordDir, 0o755); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var settings *utils.ConfigFile
+	if *configFile != "" {
+		if settings, err = utils.LoadConfigFile(*configFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, name := range settings.Sections() {
+			if r, err := utils.LookupService(name); err != nil || r.Service.Name() != name {
+				fmt.Printf("%s: section [%s] is not a problem name\n", *configFile, name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *list {
+		for _, r := range utils.Services() {
+			fmt.Printf("%d\t%-10s\t%s\n", r.Number, r.Service.Name(), r.Service.Description())
+		}
+		return
+	}
+
+	if *selftest != "" {
+		os.Exit(runSelftest(*selftest, cfg, *selftestAddr))
+	}
+
+	var selected []utils.Registered
+	if *all {
+		selected = utils.Services()
+	} else {
+		var err error
+		selected, err = parseProblems(*problemList)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("Please specify problems by number or name, or -all; -list shows what is available")
+			os.Exit(1)
+		}
+	}
+
+	if len(selected) > 1 && utils.IsPathAddress(cfg.Host) && !strings.Contains(cfg.Host, "{name}") {
+		fmt.Printf("%s can only serve one problem, add a {name} placeholder to run several\n", cfg.Host)
 		os.Exit(1)
 	}
-	fmt.Printf("Running problem %v\n", *problem)
-	problems[*problem]()
+
+	if *benchMode {
+		os.Exit(runBench(selected, cfg, settings, *benchAddr, bench.Options{Clients: *benchClients, Duration: *benchDuration}))
+	}
+
+	ctx := context.Background()
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default)
+		metricsServer = &http.Server{Addr: *metricsAddr, Handler: mux}
+		if err := serveHTTP(metricsServer); err != nil {
+			slog.Error("cannot serve metrics", "err", err)
+			os.Exit(1)
+		}
+		slog.Info("serving metrics", "addr", *metricsAddr)
+	}
+
+	var started []utils.Service
+	for _, r := range selected {
+		pcfg := problemConfig(cfg, settings, r, len(selected) > 1)
+		if err := r.Service.Start(ctx, pcfg); err != nil {
+			slog.Error("cannot start problem", "problem", r.Service.Name(), "err", err)
+			stopAll(ctx, started)
+			os.Exit(1)
+		}
+		slog.Info("running problem", "number", r.Number, "problem", r.Service.Name(), "addr", pcfg.Address())
+		started = append(started, r.Service)
+	}
+
+	var adminServer *http.Server
+	if *adminAddr != "" {
+		adminServer = &http.Server{Addr: localhostByDefault(*adminAddr), Handler: admin.NewHandler(selected)}
+		if err := serveHTTP(adminServer); err != nil {
+			slog.Error("cannot serve admin API", "err", err)
+			stopAll(ctx, started)
+			os.Exit(1)
+		}
+		slog.Info("serving admin API", "addr", adminServer.Addr)
+	}
+	// When started by a restart, the old process can stop accepting now
+	utils.NotifyReady()
+
+	// Wait for a SIGINT or SIGTERM signal to gracefully shut down the servers, or a restart signal
+	// to hand the listeners over to a new process and drain
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, append(restartSignals, syscall.SIGINT, syscall.SIGTERM)...)
+	draining := false
+	for !draining {
+		sig := <-sigChan
+		if !isRestartSignal(sig) {
+			break
+		}
+		slog.Info("restarting", "signal", sig.String())
+		child, err := utils.Restart(*restartTimeout)
+		if err != nil {
+			slog.Error("restart failed, still serving", "err", err)
+			continue
+		}
+		slog.Info("new process serving, draining connections", "pid", child.Pid, "timeout", *drainTimeout)
+		draining = true
+	}
+
+	stopHTTP := func(ctx context.Context) {
+		for _, srv := range []*http.Server{metricsServer, adminServer} {
+			if srv != nil {
+				srv.Shutdown(ctx)
+			}
+		}
+	}
+	if draining {
+		// The new process answers metrics and admin requests from now on
+		stopHTTP(ctx)
+		// Another SIGINT or SIGTERM cuts the drain short
+		dctx, cancel := context.WithTimeout(ctx, *drainTimeout)
+		go func() {
+			<-sigChan
+			cancel()
+		}()
+		drainAll(dctx, started)
+		cancel()
+	} else {
+		slog.Info("shutting down servers")
+	}
+	ctx, cancel := context.WithTimeout(ctx, *shutdownTimeout)
+	defer cancel()
+	stopAll(ctx, started)
+	stopHTTP(ctx)
+	slog.Info("servers stopped")
+}
+
+func parseProblems(list string) ([]utils.Registered, error) {
+	if list == "" {
+		return nil, fmt.Errorf("no problem selected")
+	}
+	seen := make(map[int]bool)
+	var problems []utils.Registered
+	for _, field := range strings.Split(list, ",") {
+		r, err := utils.LookupService(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if !seen[r.Number] {
+			seen[r.Number] = true
+			problems = append(problems, r)
+		}
+	}
+	return problems, nil
+}
+
+// problemConfig returns the configuration problem r runs with: cfg with the problem's settings,
+// listening on port cfg.Port+N when several problems run at once.
+func problemConfig(cfg utils.Config, settings *utils.ConfigFile, r utils.Registered, several bool) utils.Config {
+	if several {
+		cfg.Port += r.Number
+	}
+	cfg.Settings = settings.Settings(r.Service.Name())
+	return cfg
+}
+
+// localhostByDefault binds addr to the loopback interface unless it names a host.
+func localhostByDefault(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// serveHTTP serves srv on a listener a restart can hand over.
+func serveHTTP(srv *http.Server) error {
+	l, err := utils.Listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "addr", srv.Addr, "err", err)
+		}
+	}()
+	return nil
+}
+
+// drainAll drains the services that support it in parallel; see utils.Drainer.
+func drainAll(ctx context.Context, services []utils.Service) {
+	var wg sync.WaitGroup
+	for _, svc := range services {
+		d, ok := svc.(utils.Drainer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(svc utils.Service) {
+			defer wg.Done()
+			if err := d.Drain(ctx); err != nil {
+				slog.Warn("drain", "problem", svc.Name(), "err", err)
+			}
+		}(svc)
+	}
+	wg.Wait()
+}
+
+func stopAll(ctx context.Context, services []utils.Service) {
+	var wg sync.WaitGroup
+	for _, svc := range services {
+		wg.Add(1)
+		go func(svc utils.Service) {
+			defer wg.Done()
+			if err := svc.Stop(ctx); err != nil {
+				slog.Error("cannot stop problem", "problem", svc.Name(), "err", err)
+			}
+		}(svc)
+	}
+	wg.Wait()
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func envIntOr(key string, def int) int {
+	v, err := strconv.Atoi(envOr(key, strconv.Itoa(def)))
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", key, err)
+		return def
+	}
+	return v
 }
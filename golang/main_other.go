@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Restarting by signal needs SIGHUP or SIGUSR2.
+var restartSignals []os.Signal
+
+func isRestartSignal(sig os.Signal) bool {
+	return false
+}
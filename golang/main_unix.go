@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals hand the listeners over to a new process and drain the old one.
+var restartSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
+
+func isRestartSignal(sig os.Signal) bool {
+	return sig == syscall.SIGHUP || sig == syscall.SIGUSR2
+}
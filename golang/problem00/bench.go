@@ -0,0 +1,44 @@
+package problem00
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"protohackers/utils/bench"
+)
+
+func init() {
+	bench.Register("echo", func() bench.Scenario { return bench.ScenarioFunc(benchClient) })
+}
+
+// benchClient sends random payloads and waits for each to come back unmodified.
+func benchClient(ctx context.Context, addr string, _ int, rec *bench.Recorder) error {
+	conn, err := bench.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	payload := make([]byte, 1024)
+	got := make([]byte, len(payload))
+	for ctx.Err() == nil {
+		rand.Read(payload)
+		err := rec.Time(func() error {
+			if _, err := conn.Write(payload); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(conn, got); err != nil {
+				return err
+			}
+			if !bytes.Equal(payload, got) {
+				return errors.New("echoed payload differs")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
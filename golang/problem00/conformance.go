@@ -0,0 +1,73 @@
+package problem00
+
+import "protohackers/utils/conformance"
+
+func init() {
+	conformance.RegisterHealthCheck("echo", func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.Write([]byte("health\n")); err != nil {
+			return err
+		}
+		return conn.Expect([]byte("health\n"))
+	})
+	conformance.Register("echo",
+		conformance.Case{Name: "binary data comes back unmodified", Run: func(addr string) error {
+			conn, err := conformance.Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			msg := make([]byte, 256)
+			for i := range msg {
+				msg[i] = byte(i)
+			}
+			if err := conn.Write(msg); err != nil {
+				return err
+			}
+			return conn.Expect(msg)
+		}},
+		conformance.Case{Name: "everything is sent back before closing on end-of-file", Run: func(addr string) error {
+			conn, err := conformance.Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			msg := []byte("the last words\n")
+			if err := conn.Write(msg); err != nil {
+				return err
+			}
+			if err := conn.CloseWrite(); err != nil {
+				return err
+			}
+			if err := conn.Expect(msg); err != nil {
+				return err
+			}
+			return conn.ExpectClosed()
+		}},
+		conformance.Case{Name: "five simultaneous clients", Run: func(addr string) error {
+			conns := make([]*conformance.Conn, 5)
+			for i := range conns {
+				conn, err := conformance.Dial("tcp", addr)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				conns[i] = conn
+			}
+			for i := len(conns) - 1; i >= 0; i-- {
+				msg := []byte{'c', 'l', 'i', 'e', 'n', 't', '0' + byte(i)}
+				if err := conns[i].Write(msg); err != nil {
+					return err
+				}
+				if err := conns[i].Expect(msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	)
+}
@@ -1,33 +1,31 @@
 package problem00
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
-	"os"
-	"os/signal"
 	"protohackers/utils"
-	"syscall"
 )
 
-func Run() {
-	server, err := utils.NewTCPServer(utils.LISTENADDRESS, handleConnection)
+func init() {
+	utils.Register(0, utils.NewServerService("echo", "Smoke Test: TCP echo service from RFC 862", New))
+}
+
+// New returns the echo server, listening on the configured address.
+func New(cfg utils.Config) (utils.Server, error) {
+	// There is nothing to configure, so any setting is a mistake
+	if err := cfg.Settings.Decode(&struct{}{}); err != nil {
+		return nil, fmt.Errorf("settings: %w", err)
+	}
+	server, err := utils.NewTCPServer(cfg.Address(), handleConnection, cfg.TCPOptions()...)
 	if err != nil {
-		fmt.Println("error starting server: ", err)
-		return
+		return nil, err
 	}
-	server.Start()
-	// Wait for a SIGINT or SIGTERM signal to gracefully shut down the server
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-
-	fmt.Println("Shutting down server...")
-	server.Stop()
-	fmt.Println("Server stopped.")
+	return server, nil
 }
 
-func handleConnection(conn net.Conn) {
+func handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 	io.Copy(conn, conn)
 }
@@ -1,34 +1,57 @@
 package problem00
 
 import (
-	"net"
 	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"protohackers/utils/testharness"
 	"testing"
 )
 
 func TestServer(t *testing.T) {
-	// Start the server
-	server, err := utils.NewTCPServer(utils.LISTENADDRESS, handleConnection)
-	if err != nil {
-		t.Fatal(err)
-	}
-	server.Start()
-	defer server.Stop()
-	// Connect to the server and send a message
-	conn, err := net.Dial("tcp", utils.LISTENADDRESS)
-	if err != nil {
-		t.Fatal(err)
+	server := testharness.Start(t, "echo", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	msg := []byte("Testing the echo server!\n")
+	conn.Write(msg)
+	conn.Expect(msg)
+}
+
+func TestEchoUntilClientShutsDown(t *testing.T) {
+	server := testharness.Start(t, "echo", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	// Binary data comes back unmodified, and all of it before the server hangs up
+	msg := make([]byte, 256<<10)
+	for i := range msg {
+		msg[i] = byte(i)
 	}
-	defer conn.Close()
-	msg := "Testing the echo server!\n"
-	actual := make([]byte, len(msg))
-	if _, err := conn.Write([]byte(msg)); err != nil {
-		t.Fatal(err)
+	go conn.NetConn().Write(msg)
+	got := conn.ReadN(len(msg) / 2)
+	conn.CloseWrite()
+	got = append(got, conn.ReadN(len(msg)/2)...)
+	for i := range msg {
+		if got[i] != msg[i] {
+			t.Fatalf("byte %d came back as %d, expected %d", i, got[i], msg[i])
+		}
 	}
-	if _, err := conn.Read(actual); err != nil {
-		t.Fatal(err)
+	conn.ExpectClosed()
+}
+
+func TestUnknownSetting(t *testing.T) {
+	cfg := testharness.Config("echo")
+	cfg.Settings = utils.Settings{"foo": "1"}
+	if _, err := New(cfg); err == nil {
+		t.Error("expected an error for a setting echo does not have")
 	}
-	if string(actual) != msg {
-		t.Errorf("expected %q, but got %q", msg, actual)
+}
+
+func TestConformance(t *testing.T) {
+	server := testharness.Start(t, "echo", New)
+	for _, c := range conformance.Cases("echo") {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.Run(server.Addr); err != nil {
+				t.Fatal(err)
+			}
+		})
 	}
 }
@@ -0,0 +1,48 @@
+package problem01
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"protohackers/utils/bench"
+)
+
+func init() {
+	bench.Register("prime", func() bench.Scenario { return bench.ScenarioFunc(benchClient) })
+}
+
+// benchClient asks about random numbers one line at a time and checks every answer.
+func benchClient(ctx context.Context, addr string, _ int, rec *bench.Recorder) error {
+	conn, err := bench.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for ctx.Err() == nil {
+		n := rand.Intn(1 << 24)
+		err := rec.Time(func() error {
+			if _, err := fmt.Fprintf(conn, "{\"method\":\"isPrime\",\"number\":%d}\n", n); err != nil {
+				return err
+			}
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				return err
+			}
+			var resp Response
+			if err := json.Unmarshal(line, &resp); err != nil {
+				return fmt.Errorf("malformed response %q", line)
+			}
+			if resp.Method != "isPrime" || resp.Prime != isPrime(float64(n)) {
+				return fmt.Errorf("wrong answer for %d: %q", n, line)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package problem01
+
+import (
+	"fmt"
+	"protohackers/utils/conformance"
+)
+
+const (
+	answerPrime    = `{"method":"isPrime","prime":true}`
+	answerNotPrime = `{"method":"isPrime","prime":false}`
+)
+
+// conformingRequests are answered, the connection staying open for the next one.
+var conformingRequests = []struct {
+	name, request, response string
+}{
+	{"spec example", `{"method":"isPrime","number":123}`, answerNotPrime},
+	{"prime", `{"method":"isPrime","number":7919}`, answerPrime},
+	{"two", `{"method":"isPrime","number":2}`, answerPrime},
+	{"one", `{"method":"isPrime","number":1}`, answerNotPrime},
+	{"zero", `{"method":"isPrime","number":0}`, answerNotPrime},
+	{"negative", `{"method":"isPrime","number":-7}`, answerNotPrime},
+	{"non-integer", `{"method":"isPrime","number":7.5}`, answerNotPrime},
+	{"huge", `{"method":"isPrime","number":1e300}`, answerNotPrime},
+	{"extraneous fields ignored", `{"method":"isPrime","number":13,"extra":[1,2]}`, answerPrime},
+	{"fields in any order", `{"number":17,"method":"isPrime"}`, answerPrime},
+}
+
+// malformedRequests get a malformed response, then the server hangs up.
+var malformedRequests = []struct {
+	name, request string
+}{
+	{"not JSON", `isPrime 7`},
+	{"not an object", `[7]`},
+	{"missing method", `{"number":7}`},
+	{"wrong method", `{"method":"isEven","number":7}`},
+	{"field names are case sensitive", `{"Method":"isPrime","Number":7}`},
+	{"missing number", `{"method":"isPrime"}`},
+	{"number as a string", `{"method":"isPrime","number":"7"}`},
+	{"number as a boolean", `{"method":"isPrime","number":true}`},
+}
+
+func init() {
+	conformance.RegisterHealthCheck("prime", func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.WriteLine(`{"method":"isPrime","number":7}`); err != nil {
+			return err
+		}
+		return conn.ExpectLine(answerPrime)
+	})
+	for _, tc := range conformingRequests {
+		tc := tc
+		conformance.Register("prime", conformance.Case{Name: tc.name, Run: func(addr string) error {
+			conn, err := conformance.Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			if err := conn.WriteLine(tc.request); err != nil {
+				return err
+			}
+			if err := conn.ExpectLine(tc.response); err != nil {
+				return err
+			}
+			// Still serving
+			if err := conn.WriteLine(`{"method":"isPrime","number":3}`); err != nil {
+				return err
+			}
+			return conn.ExpectLine(answerPrime)
+		}})
+	}
+	for _, tc := range malformedRequests {
+		tc := tc
+		conformance.Register("prime", conformance.Case{Name: "malformed: " + tc.name, Run: func(addr string) error {
+			conn, err := conformance.Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			if err := conn.WriteLine(tc.request); err != nil {
+				return err
+			}
+			line, err := conn.ReadLine()
+			if err != nil {
+				return err
+			}
+			if line == answerPrime || line == answerNotPrime {
+				return fmt.Errorf("expected a malformed response, got %q", line)
+			}
+			return conn.ExpectClosed()
+		}})
+	}
+	conformance.Register("prime", conformance.Case{Name: "pipelined requests answered in order", Run: func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.Write([]byte(`{"method":"isPrime","number":4}` + "\n" + `{"method":"isPrime","number":5}` + "\n")); err != nil {
+			return err
+		}
+		if err := conn.ExpectLine(answerNotPrime); err != nil {
+			return err
+		}
+		return conn.ExpectLine(answerPrime)
+	}})
+}
@@ -2,14 +2,12 @@ package problem01
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
 	"net"
-	"os"
-	"os/signal"
 	"protohackers/utils"
-	"syscall"
 )
 
 type Request struct {
@@ -22,55 +20,70 @@ type Response struct {
 	Prime  bool   `json:"prime"`
 }
 
-func Run() {
-	server, err := utils.NewTCPServer(utils.LISTENADDRESS, handleConnection01)
+func init() {
+	utils.Register(1, utils.NewServerService("prime", "Prime Time: JSON line protocol answering isPrime requests", New))
+	utils.RegisterDecoder("prime", utils.LineDecoder)
+}
+
+// New returns the primality testing server, listening on the configured address.
+func New(cfg utils.Config) (utils.Server, error) {
+	// There is nothing to configure, so any setting is a mistake
+	if err := cfg.Settings.Decode(&struct{}{}); err != nil {
+		return nil, fmt.Errorf("settings: %w", err)
+	}
+	server, err := utils.NewTCPServer(cfg.Address(), handleConnection01, cfg.TCPOptions()...)
 	if err != nil {
-		fmt.Println("error starting server: ", err)
-		return
+		return nil, err
 	}
-	server.Start()
-	// Wait for a SIGINT or SIGTERM signal to gracefully shut down the server
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-
-	fmt.Println("Shutting down server...")
-	server.Stop()
-	fmt.Println("Server stopped.")
+	return server, nil
 }
 
-func handleConnection01(conn net.Conn) {
+func handleConnection01(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
+	logger := utils.LoggerFromContext(ctx)
 	buf := bufio.NewReader(conn)
 	for {
 		bytes, err := buf.ReadBytes('\n')
 		if err != nil {
-			fmt.Println(fmt.Errorf("could not read data: %w", err))
+			logger.Debug("could not read data", "err", err)
 			break
 		}
+		logger.Debug("received", "request", string(bytes))
 		response := verifyRequest(bytes)
+		utils.CountMessage(ctx, response.Method)
 		resp, err := json.Marshal(response)
 		if err != nil {
-			fmt.Println("Errore sucando json: ", err)
+			logger.Error("cannot marshal response", "err", err)
 			continue
 		}
 		resp = append(resp, '\n')
 		conn.Write(resp)
-		fmt.Println("Sending:", string(resp))
+		logger.Debug("sending", "response", string(resp))
+		if response.Method == "invalid" {
+			// Malformed requests get a single malformed response, then the client is disconnected
+			break
+		}
 	}
 }
 
 func verifyRequest(data []byte) Response {
+	// Fields are matched exactly, where decoding into Request would also take "Method"
+	var fields map[string]json.RawMessage
 	var req Request
-	fmt.Println("Received:", string(data))
-	err := json.Unmarshal(data, &req)
-	if err != nil || req.Method == nil || req.Number == nil || *req.Method != "isPrime" {
+	if json.Unmarshal(data, &fields) != nil ||
+		json.Unmarshal(fields["method"], &req.Method) != nil ||
+		json.Unmarshal(fields["number"], &req.Number) != nil ||
+		req.Method == nil || req.Number == nil || *req.Method != "isPrime" {
 		return Response{"invalid", false}
 	}
 	return Response{"isPrime", isPrime(*req.Number)}
 }
 
 func isPrime(n float64) bool {
+	// Non-integers can not be prime, and every float64 above 2^53 is even
+	if n != math.Trunc(n) || n > 1<<53 {
+		return false
+	}
 	intn := int(n)
 	if intn <= 1 {
 		return false
@@ -1,34 +1,127 @@
 package problem01
 
 import (
-	"net"
+	"encoding/json"
+	"math/big"
 	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"protohackers/utils/testharness"
 	"testing"
 )
 
 func TestServer(t *testing.T) {
-	// Start the server
-	server, err := utils.NewTCPServer(utils.LISTENADDRESS, handleConnection01)
-	if err != nil {
-		t.Fatal(err)
+	server := testharness.Start(t, "prime", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	conn.WriteLine(`{"method":"isPrime","number":123}`)
+	conn.ExpectLine(`{"method":"isPrime","prime":false}`)
+	conn.WriteLine(`{"method":"isPrime","number":7919}`)
+	conn.ExpectLine(`{"method":"isPrime","prime":true}`)
+}
+
+func TestMalformedRequest(t *testing.T) {
+	server := testharness.Start(t, "prime", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	conn.WriteLine(`{"method":"isPrime","number":"7"}`)
+	// Anything that isn't a well-formed response will do, followed by the server hanging up
+	if line := conn.ReadLine(); line == `{"method":"isPrime","prime":true}` || line == `{"method":"isPrime","prime":false}` {
+		t.Fatalf("expected a malformed response, got %q", line)
+	}
+	conn.ExpectClosed()
+}
+
+func TestNothingAnsweredAfterMalformedRequest(t *testing.T) {
+	server := testharness.Start(t, "prime", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	// Both requests in one write: the second is buffered, but the client is already gone
+	conn.Write([]byte("{\"method\":\"isPrime\",\"number\":7}\n{}\n{\"method\":\"isPrime\",\"number\":7}\n"))
+	conn.ExpectLine(`{"method":"isPrime","prime":true}`)
+	conn.ReadLine()
+	conn.ExpectClosed()
+}
+
+func TestUnknownSetting(t *testing.T) {
+	cfg := testharness.Config("prime")
+	cfg.Settings = utils.Settings{"foo": "1"}
+	if _, err := New(cfg); err == nil {
+		t.Error("expected an error for a setting prime does not have")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	server := testharness.Start(t, "prime", New)
+	for _, c := range conformance.Cases("prime") {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.Run(server.Addr); err != nil {
+				t.Fatal(err)
+			}
+		})
 	}
-	server.Start()
-	defer server.Stop()
-	conn, err := net.Dial("tcp", utils.LISTENADDRESS)
-	if err != nil {
-		t.Fatal(err)
+}
+
+func FuzzVerifyRequest(f *testing.F) {
+	for _, tc := range conformingRequests {
+		f.Add([]byte(tc.request))
 	}
-	defer conn.Close()
-	request := `{"method":"isPrime","number":123}`
-	expected := `{"method":"isPrime","prime":false}`
-	actual := make([]byte, 1024)
-	if _, err := conn.Write([]byte(request)); err != nil {
-		t.Fatal(err)
+	for _, tc := range malformedRequests {
+		f.Add([]byte(tc.request))
 	}
-	if _, err := conn.Read(actual); err != nil {
-		t.Fatal(err)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resp := verifyRequest(data)
+		if resp.Method == "invalid" {
+			if resp.Prime {
+				t.Fatalf("malformed request %q answered prime", data)
+			}
+			return
+		}
+		// Answered requests are objects with an isPrime method and a number
+		var req map[string]any
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatalf("answered %q: %v", data, err)
+		}
+		number, ok := req["number"].(float64)
+		if req["method"] != "isPrime" || !ok {
+			t.Fatalf("answered %q", data)
+		}
+		if resp.Prime != isPrime(number) {
+			t.Fatalf("%q answered prime=%v", data, resp.Prime)
+		}
+	})
+}
+
+func FuzzIsPrime(f *testing.F) {
+	for _, n := range []int64{-7, 0, 1, 2, 3, 4, 123, 7919, 1<<31 - 1} {
+		f.Add(n)
 	}
-	if string(actual) != expected {
-		t.Errorf("expected %q, but got %q", expected, actual)
+	f.Fuzz(func(t *testing.T, n int64) {
+		// Beyond 2^40 trial division is too slow to fuzz
+		if n > 1<<40 || n < -1<<40 {
+			return
+		}
+		if got, want := isPrime(float64(n)), big.NewInt(n).ProbablyPrime(0); got != want {
+			t.Fatalf("isPrime(%d) = %v", n, got)
+		}
+	})
+}
+
+func TestIsPrime(t *testing.T) {
+	for _, tc := range []struct {
+		n    float64
+		want bool
+	}{
+		{7, true},
+		{7919, true},
+		{2, true},
+		{1, false},
+		{-7, false},
+		{7.5, false},
+		{1 << 53, false},
+		{1<<53 + 2, false},
+		{1e300, false},
+	} {
+		if got := isPrime(tc.n); got != tc.want {
+			t.Errorf("isPrime(%v) = %v, expected %v", tc.n, got, tc.want)
+		}
 	}
 }
@@ -0,0 +1,63 @@
+package problem02
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"protohackers/utils/bench"
+)
+
+func init() {
+	bench.Register("means", func() bench.Scenario { return bench.ScenarioFunc(benchClient) })
+}
+
+// benchPrices is how many prices are inserted before each query.
+const benchPrices = 10
+
+// benchClient inserts batches of prices and queries their mean, which it works out as well.
+func benchClient(ctx context.Context, addr string, _ int, rec *bench.Recorder) error {
+	conn, err := bench.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var timestamp int32
+	buf := make([]byte, 0, 9*(benchPrices+1))
+	answer := make([]byte, 4)
+	for ctx.Err() == nil {
+		buf = buf[:0]
+		from := timestamp + 1
+		var sum int64
+		for i := 0; i < benchPrices; i++ {
+			timestamp++
+			price := rand.Int31n(10000)
+			sum += int64(price)
+			buf = appendMessage(buf, 'I', timestamp, price)
+		}
+		buf = appendMessage(buf, 'Q', from, timestamp)
+		err := rec.Time(func() error {
+			if _, err := conn.Write(buf); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(conn, answer); err != nil {
+				return err
+			}
+			if got, want := int32(binary.BigEndian.Uint32(answer)), int32(sum/benchPrices); got != want {
+				return fmt.Errorf("mean %d, expected %d", got, want)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendMessage(buf []byte, kind byte, a, b int32) []byte {
+	buf = append(buf, kind)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(a))
+	return binary.BigEndian.AppendUint32(buf, uint32(b))
+}
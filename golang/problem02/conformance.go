@@ -0,0 +1,164 @@
+package problem02
+
+import (
+	"encoding/binary"
+	"fmt"
+	"protohackers/utils/conformance"
+)
+
+type sessionMessage struct {
+	kind byte
+	a, b int32
+}
+
+func (m sessionMessage) bytes() []byte {
+	msg := []byte{m.kind}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(m.a))
+	return binary.BigEndian.AppendUint32(msg, uint32(m.b))
+}
+
+// sessions are sent over a connection each, the answers to their queries expected in order.
+var sessions = []struct {
+	name     string
+	messages []sessionMessage
+	means    []int32
+}{
+	{
+		"spec example",
+		[]sessionMessage{{'I', 12345, 101}, {'I', 12346, 102}, {'I', 12347, 100}, {'I', 40960, 5}, {'Q', 12288, 16384}},
+		[]int32{101},
+	},
+	{
+		"bounds are inclusive",
+		[]sessionMessage{{'I', 10, 100}, {'I', 20, 200}, {'I', 30, 900}, {'Q', 10, 20}},
+		[]int32{150},
+	},
+	{
+		"inserts in any order",
+		[]sessionMessage{{'I', 300, 30}, {'I', 100, 10}, {'I', 200, 20}, {'Q', 100, 200}, {'Q', 200, 300}},
+		[]int32{15, 25},
+	},
+	{
+		"negative prices and timestamps",
+		[]sessionMessage{{'I', -2, -5}, {'I', -1, -15}, {'Q', -10, 0}},
+		[]int32{-10},
+	},
+	{
+		"large prices don't overflow",
+		[]sessionMessage{{'I', 1, 2147483647}, {'I', 2, 2147483647}, {'Q', 1, 2}},
+		[]int32{2147483647},
+	},
+	{
+		"no prices in the period",
+		[]sessionMessage{{'I', 1, 100}, {'Q', 2, 10}},
+		[]int32{0},
+	},
+	{
+		"mintime after maxtime",
+		[]sessionMessage{{'I', 5, 100}, {'Q', 10, 1}},
+		[]int32{0},
+	},
+	{
+		"query before any insert",
+		[]sessionMessage{{'Q', 0, 100}},
+		[]int32{0},
+	},
+}
+
+// expectMean returns an error unless the answer to the next query is want.
+func expectMean(conn *conformance.Conn, want int32) error {
+	buf, err := conn.ReadN(4)
+	if err != nil {
+		return err
+	}
+	if got := int32(binary.BigEndian.Uint32(buf)); got != want {
+		return fmt.Errorf("expected mean %d, got %d", want, got)
+	}
+	return nil
+}
+
+func init() {
+	conformance.RegisterHealthCheck("means", func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.Write(append(sessionMessage{'I', 1, 100}.bytes(), sessionMessage{'Q', 0, 2}.bytes()...)); err != nil {
+			return err
+		}
+		return conn.Expect(binary.BigEndian.AppendUint32(nil, 100))
+	})
+	for _, tc := range sessions {
+		tc := tc
+		conformance.Register("means", conformance.Case{Name: tc.name, Run: func(addr string) error {
+			conn, err := conformance.Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			var all []byte
+			for _, msg := range tc.messages {
+				all = append(all, msg.bytes()...)
+			}
+			if err := conn.Write(all); err != nil {
+				return err
+			}
+			for _, want := range tc.means {
+				if err := expectMean(conn, want); err != nil {
+					return err
+				}
+			}
+			return nil
+		}})
+	}
+	conformance.Register("means", conformance.Case{Name: "each session has its own prices", Run: func(addr string) error {
+		alice, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer alice.Close()
+		bob, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer bob.Close()
+		for _, send := range []struct {
+			conn *conformance.Conn
+			msg  sessionMessage
+		}{
+			{alice, sessionMessage{'I', 1, 100}},
+			{bob, sessionMessage{'I', 1, 300}},
+			{alice, sessionMessage{'Q', 0, 10}},
+			{bob, sessionMessage{'Q', 0, 10}},
+		} {
+			if err := send.conn.Write(send.msg.bytes()); err != nil {
+				return err
+			}
+		}
+		if err := expectMean(alice, 100); err != nil {
+			return fmt.Errorf("alice: %w", err)
+		}
+		if err := expectMean(bob, 300); err != nil {
+			return fmt.Errorf("bob: %w", err)
+		}
+		return nil
+	}})
+	conformance.Register("means", conformance.Case{Name: "messages split across writes", Run: func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		var all []byte
+		for _, msg := range []sessionMessage{{'I', 1, 40}, {'I', 2, 60}, {'Q', 1, 2}} {
+			all = append(all, msg.bytes()...)
+		}
+		for _, b := range all {
+			if err := conn.Write([]byte{b}); err != nil {
+				return err
+			}
+		}
+		return expectMean(conn, 50)
+	}})
+}
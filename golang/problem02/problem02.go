@@ -1,10 +1,13 @@
 package problem02
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"protohackers/utils"
 )
 
@@ -13,38 +16,54 @@ type PriceItem struct {
 	price     int32
 }
 
-func Run() {
-	server, err := utils.NewTCPServer(utils.LISTENADDRESS, handleConnection)
+func init() {
+	utils.Register(2, utils.NewServerService("means", "Means to an End: binary protocol storing prices and answering mean queries", New))
+}
+
+// New returns the price history server, listening on the configured address.
+func New(cfg utils.Config) (utils.Server, error) {
+	// There is nothing to configure, so any setting is a mistake
+	if err := cfg.Settings.Decode(&struct{}{}); err != nil {
+		return nil, fmt.Errorf("settings: %w", err)
+	}
+	server, err := utils.NewTCPServer(cfg.Address(), handleConnection, cfg.TCPOptions()...)
 	if err != nil {
-		fmt.Println("error starting server: ", err)
-		return
+		return nil, err
 	}
-	server.Start()
-	server.Stop()
+	return server, nil
 }
 
-func handleConnection(conn net.Conn) {
+func handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
+	logger := utils.LoggerFromContext(ctx)
 	var pricehistory []PriceItem
 	in_msgbuf := make([]byte, 9)
 	out_msgbuf := make([]byte, 4)
 	for {
 		bytes, err := io.ReadFull(conn, in_msgbuf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			logger.Info("client timed out, closing connection")
+			break
+		}
 		if bytes < 9 || err != nil {
-			fmt.Println(fmt.Errorf("could not read data: %w", err))
+			logger.Debug("could not read data", "err", err)
 			break
 		}
 		a := int32(binary.BigEndian.Uint32(in_msgbuf[1:5]))
 		b := int32(binary.BigEndian.Uint32(in_msgbuf[5:]))
 		switch in_msgbuf[0] {
 		case 'I':
+			utils.CountMessage(ctx, "insert")
 			pricehistory = append(pricehistory, PriceItem{a, b})
-			//fmt.Printf("history: %v\n", pricehistory)
+			logger.Debug("insert", "timestamp", a, "price", b)
 		case 'Q':
+			utils.CountMessage(ctx, "query")
 			mean := calc_mean(pricehistory, a, b)
-			//fmt.Printf("query: %v - %v -> mean %v \n", a, b, mean)
+			logger.Debug("query", "mintime", a, "maxtime", b, "mean", mean)
 			binary.BigEndian.PutUint32(out_msgbuf, uint32(mean))
 			conn.Write(out_msgbuf)
+		default:
+			utils.CountMessage(ctx, "invalid")
 		}
 	}
 }
@@ -53,7 +72,6 @@ func calc_mean(pricehistory []PriceItem, time_start int32, time_end int32) int32
 	var total int64
 	var n int64
 	for _, item := range pricehistory {
-		//fmt.Printf("item timestamp: %v\n", item.timestamp)
 		if item.timestamp >= time_start && item.timestamp <= time_end {
 			total += int64(item.price)
 			n += 1
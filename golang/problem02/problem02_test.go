@@ -0,0 +1,115 @@
+package problem02
+
+import (
+	"encoding/binary"
+	"math"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"protohackers/utils/testharness"
+	"testing"
+)
+
+var decoder = utils.DecoderFunc(decodeFrame)
+
+func message(kind byte, a, b int32) []byte {
+	msg := []byte{kind}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(a))
+	return binary.BigEndian.AppendUint32(msg, uint32(b))
+}
+
+func TestExampleSession(t *testing.T) {
+	server := testharness.Start(t, "means", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	conn.Write(message('I', 12345, 101))
+	conn.Write(message('I', 12346, 102))
+	conn.Write(message('I', 12347, 100))
+	conn.Write(message('I', 40960, 5))
+	conn.Write(message('Q', 12288, 16384))
+	conn.ExpectFrame(decoder, "101")
+}
+
+func TestSessionsAreSeparate(t *testing.T) {
+	server := testharness.Start(t, "means", New)
+	alice := testharness.Dial(t, "tcp", server.Addr)
+	bob := testharness.Dial(t, "tcp", server.Addr)
+	alice.Write(message('I', 1, 100))
+	bob.Write(message('I', 1, -300))
+	bob.Write(message('I', 2, -100))
+	alice.Write(message('Q', 0, 10))
+	alice.ExpectFrame(decoder, "100")
+	bob.Write(message('Q', 0, 10))
+	bob.ExpectFrame(decoder, "-200")
+	// An empty or backwards period has no prices, so the mean is 0
+	bob.Write(message('Q', 10, 0))
+	bob.ExpectFrame(decoder, "0")
+}
+
+func TestUnknownSetting(t *testing.T) {
+	cfg := testharness.Config("means")
+	cfg.Settings = utils.Settings{"foo": "1"}
+	if _, err := New(cfg); err == nil {
+		t.Error("expected an error for a setting means does not have")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	server := testharness.Start(t, "means", New)
+	for _, c := range conformance.Cases("means") {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.Run(server.Addr); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+	// The spec's example session
+	for _, msg := range [][]byte{
+		message('I', 12345, 101),
+		message('I', 12346, 102),
+		message('I', 12347, 100),
+		message('I', 40960, 5),
+		message('Q', 12288, 16384),
+		{0x00, 0x00, 0x00, 0x65},
+	} {
+		f.Add(msg, msg[0] == 'I' || msg[0] == 'Q')
+	}
+	f.Fuzz(func(t *testing.T, buf []byte, inbound bool) {
+		size := 4
+		if inbound {
+			size = 9
+		}
+		n, desc := decodeFrame(buf, inbound)
+		if (len(buf) < size && n != 0) || (len(buf) >= size && (n != size || desc == "")) {
+			t.Fatalf("decodeFrame(% x, %v) = %d, %q", buf, inbound, n, desc)
+		}
+	})
+}
+
+func FuzzCalcMean(f *testing.F) {
+	var history []byte
+	for _, msg := range [][]byte{message('I', 12345, 101), message('I', 12346, 102), message('I', 12347, 100), message('I', 40960, 5)} {
+		history = append(history, msg[1:]...)
+	}
+	f.Add(history, int32(12288), int32(16384))
+	f.Add(history, int32(16384), int32(12288))
+	f.Fuzz(func(t *testing.T, data []byte, start, end int32) {
+		var prices []PriceItem
+		for ; len(data) >= 8; data = data[8:] {
+			prices = append(prices, PriceItem{int32(binary.BigEndian.Uint32(data)), int32(binary.BigEndian.Uint32(data[4:]))})
+		}
+		lo, hi, n := int32(math.MaxInt32), int32(math.MinInt32), 0
+		for _, p := range prices {
+			if p.timestamp >= start && p.timestamp <= end {
+				lo, hi, n = min(lo, p.price), max(hi, p.price), n+1
+			}
+		}
+		mean := calc_mean(prices, start, end)
+		if (n == 0 && mean != 0) || (n > 0 && (mean < lo || mean > hi)) {
+			t.Fatalf("mean of %d prices between %d and %d is %d", n, lo, hi, mean)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package problem02
+
+import (
+	"encoding/binary"
+	"fmt"
+	"protohackers/utils"
+)
+
+func init() {
+	utils.RegisterDecoder("means", utils.DecoderFunc(decodeFrame))
+}
+
+// decodeFrame describes messages the way the spec's example session does: "I 12345 101" for
+// the 9 byte requests and the mean for the 4 byte answers.
+func decodeFrame(buf []byte, inbound bool) (int, string) {
+	if !inbound {
+		if len(buf) < 4 {
+			return 0, ""
+		}
+		return 4, fmt.Sprint(int32(binary.BigEndian.Uint32(buf)))
+	}
+	if len(buf) < 9 {
+		return 0, ""
+	}
+	a := int32(binary.BigEndian.Uint32(buf[1:5]))
+	b := int32(binary.BigEndian.Uint32(buf[5:9]))
+	switch buf[0] {
+	case 'I', 'Q':
+		return 9, fmt.Sprintf("%c %d %d", buf[0], a, b)
+	}
+	return 9, fmt.Sprintf("invalid type 0x%02x %d %d", buf[0], a, b)
+}
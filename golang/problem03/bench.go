@@ -0,0 +1,76 @@
+package problem03
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"protohackers/utils/bench"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	bench.Register("budgetchat", func() bench.Scenario { return bench.ScenarioFunc(benchClient) })
+}
+
+// benchMessageInterval is how often each simulated user says something.
+const benchMessageInterval = 100 * time.Millisecond
+
+// benchClient joins the room and chats, stamping each message with the time it was sent. The
+// latency observed is the time a message took to reach each of the other users.
+func benchClient(ctx context.Context, addr string, id int, rec *bench.Recorder) error {
+	conn, err := bench.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if _, err := r.ReadString('\n'); err != nil {
+		return fmt.Errorf("no welcome: %w", err)
+	}
+	if _, err := fmt.Fprintf(conn, "bench%d\n", id); err != nil {
+		return err
+	}
+	if line, err := r.ReadString('\n'); err != nil {
+		return fmt.Errorf("no room listing: %w", err)
+	} else if !strings.HasPrefix(line, "*") {
+		return fmt.Errorf("unexpected room listing %q", line)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				errc <- err
+				return
+			}
+			if !strings.HasPrefix(line, "[") {
+				continue // Presence notification
+			}
+			_, text, _ := strings.Cut(strings.TrimSuffix(line, "\n"), "] ")
+			sent, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				rec.Error(fmt.Errorf("unexpected chat message %q", line))
+				continue
+			}
+			rec.Observe(time.Since(time.Unix(0, sent)))
+		}
+	}()
+
+	ticker := time.NewTicker(benchMessageInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := fmt.Fprintf(conn, "%d\n", time.Now().UnixNano()); err != nil {
+				return err
+			}
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package problem03
+
+import (
+	"fmt"
+	"protohackers/utils/conformance"
+	"strings"
+	"time"
+)
+
+// chat is one scenario's view of the room. Lines that don't mention any of its users are
+// skipped, so scenarios can share a server with each other and with real users.
+type chat struct {
+	addr  string
+	users []string
+	conns []*conformance.Conn
+}
+
+// user returns a name unique to the run, that the scenario is interested in.
+func (c *chat) user(prefix string) string {
+	name := conformance.Unique(prefix)
+	c.users = append(c.users, name)
+	return name
+}
+
+// connect returns a client that has been asked for a name.
+func (c *chat) connect() (*conformance.Conn, error) {
+	conn, err := conformance.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	c.conns = append(c.conns, conn)
+	if _, err := conn.ReadLine(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// join connects as name and checks the room listing names everybody present.
+func (c *chat) join(name string, present ...string) (*conformance.Conn, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteLine(name); err != nil {
+		return nil, err
+	}
+	line, err := conn.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected the room listing, got %q", line)
+	}
+	for _, name := range present {
+		if !strings.Contains(line, name) {
+			return nil, fmt.Errorf("expected the room listing %q to mention %s", line, name)
+		}
+	}
+	return conn, nil
+}
+
+// next returns the next line mentioning one of the scenario's users.
+func (c *chat) next(conn *conformance.Conn) (string, error) {
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		for _, name := range c.users {
+			if strings.Contains(line, name) {
+				return line, nil
+			}
+		}
+	}
+}
+
+func (c *chat) expectLine(conn *conformance.Conn, want string) error {
+	got, err := c.next(conn)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("expected %q, got %q", want, got)
+	}
+	return nil
+}
+
+// expectNotice returns an error unless the next line is a notice about name, which the spec
+// only requires to start with an asterisk and mention the user.
+func (c *chat) expectNotice(conn *conformance.Conn, name string) error {
+	line, err := c.next(conn)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "*") || !strings.Contains(line, name) {
+		return fmt.Errorf("expected a notice about %s, got %q", name, line)
+	}
+	return nil
+}
+
+// pair joins first, then second, and checks first is told second joined.
+func (c *chat) pair(first, second string) (*conformance.Conn, *conformance.Conn, error) {
+	firstConn, err := c.join(first)
+	if err != nil {
+		return nil, nil, err
+	}
+	secondConn, err := c.join(second, first)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.expectNotice(firstConn, second); err != nil {
+		return nil, nil, err
+	}
+	return firstConn, secondConn, nil
+}
+
+// close disconnects every client of the scenario.
+func (c *chat) close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// illegalNames must be refused, with nobody else told about them. "{name}" stands for a name
+// unique to the run.
+var illegalNames = []struct {
+	name, username string
+}{
+	{"space", "{name} smith"},
+	{"punctuation", "{name}!"},
+	{"underscore", "{name}_smith"},
+	{"longer than 16 characters", "{name}abcdefghijk"},
+}
+
+// chatCase registers a scenario run with its own view of the room.
+func chatCase(name string, run func(c *chat) error) {
+	conformance.Register("budgetchat", conformance.Case{Name: name, Run: func(addr string) error {
+		c := &chat{addr: addr}
+		defer c.close()
+		return run(c)
+	}})
+}
+
+func init() {
+	// Clients that never give a name are not announced, so the check goes unnoticed in the room
+	conformance.RegisterHealthCheck("budgetchat", func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.ReadLine()
+		return err
+	})
+	chatCase("spec example session", func(c *chat) error {
+		bob, charlie, dave, alice := c.user("bob"), c.user("charlie"), c.user("dave"), c.user("alice")
+		bobConn, err := c.join(bob)
+		if err != nil {
+			return err
+		}
+		charlieConn, err := c.join(charlie, bob)
+		if err != nil {
+			return err
+		}
+		if err := c.expectNotice(bobConn, charlie); err != nil {
+			return err
+		}
+		daveConn, err := c.join(dave, bob, charlie)
+		if err != nil {
+			return err
+		}
+		if err := c.expectNotice(bobConn, dave); err != nil {
+			return err
+		}
+		if err := c.expectNotice(charlieConn, dave); err != nil {
+			return err
+		}
+
+		aliceConn, err := c.join(alice, bob, charlie, dave)
+		if err != nil {
+			return err
+		}
+		for _, conn := range []*conformance.Conn{bobConn, charlieConn, daveConn} {
+			if err := c.expectNotice(conn, alice); err != nil {
+				return err
+			}
+		}
+		if err := aliceConn.WriteLine("Hello everyone"); err != nil {
+			return err
+		}
+		for _, conn := range []*conformance.Conn{bobConn, charlieConn, daveConn} {
+			if err := c.expectLine(conn, "["+alice+"] Hello everyone"); err != nil {
+				return err
+			}
+		}
+		if err := bobConn.WriteLine("hi alice"); err != nil {
+			return err
+		}
+		if err := c.expectLine(aliceConn, "["+bob+"] hi alice"); err != nil {
+			return err
+		}
+		if err := charlieConn.WriteLine("hello alice"); err != nil {
+			return err
+		}
+		if err := c.expectLine(aliceConn, "["+charlie+"] hello alice"); err != nil {
+			return err
+		}
+		daveConn.Close()
+		return c.expectNotice(aliceConn, dave)
+	})
+	chatCase("messages are not sent back to the sender", func(c *chat) error {
+		alice, bob := c.user("alice"), c.user("bob")
+		aliceConn, bobConn, err := c.pair(alice, bob)
+		if err != nil {
+			return err
+		}
+		if err := aliceConn.WriteLine("anyone there?"); err != nil {
+			return err
+		}
+		if err := c.expectLine(bobConn, "["+alice+"] anyone there?"); err != nil {
+			return err
+		}
+		// Alice hears bob's answer first, not her own message
+		if err := bobConn.WriteLine("yes"); err != nil {
+			return err
+		}
+		return c.expectLine(aliceConn, "["+bob+"] yes")
+	})
+	chatCase("clients that haven't joined hear nothing", func(c *chat) error {
+		lurker, err := c.connect()
+		if err != nil {
+			return err
+		}
+		alice, bob := c.user("alice"), c.user("bob")
+		aliceConn, bobConn, err := c.pair(alice, bob)
+		if err != nil {
+			return err
+		}
+		if err := aliceConn.WriteLine("psst"); err != nil {
+			return err
+		}
+		if err := c.expectLine(bobConn, "["+alice+"] psst"); err != nil {
+			return err
+		}
+		bobConn.Close()
+		if err := c.expectNotice(aliceConn, bob); err != nil {
+			return err
+		}
+		return lurker.ExpectSilence(200 * time.Millisecond)
+	})
+	chatCase("names of 16 characters are allowed", func(c *chat) error {
+		_, _, err := c.pair(c.user("alice"), c.user("ABCD123456"))
+		return err
+	})
+	chatCase("messages of 1000 characters are relayed", func(c *chat) error {
+		alice, bob := c.user("alice"), c.user("bob")
+		aliceConn, bobConn, err := c.pair(alice, bob)
+		if err != nil {
+			return err
+		}
+		msg := strings.Repeat("x", 1000)
+		if err := aliceConn.WriteLine(msg); err != nil {
+			return err
+		}
+		return c.expectLine(bobConn, "["+alice+"] "+msg)
+	})
+	for _, tc := range illegalNames {
+		tc := tc
+		chatCase("illegal name: "+tc.name, func(c *chat) error {
+			alice, bob := c.user("alice"), c.user("bob")
+			illegal := strings.ReplaceAll(tc.username, "{name}", c.user("x"))
+			aliceConn, err := c.join(alice)
+			if err != nil {
+				return err
+			}
+			conn, err := c.connect()
+			if err != nil {
+				return err
+			}
+			if err := conn.WriteLine(illegal); err != nil {
+				return err
+			}
+			// An informative error message is allowed before the disconnect
+			if _, err := conn.ReadAll(); err != nil {
+				return err
+			}
+			// The next alice hears of is bob, nothing about the refused user
+			if _, err := c.join(bob, alice); err != nil {
+				return err
+			}
+			return c.expectNotice(aliceConn, bob)
+		})
+	}
+	chatCase("illegal name: empty", func(c *chat) error {
+		conn, err := c.connect()
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteLine(""); err != nil {
+			return err
+		}
+		_, err = conn.ReadAll()
+		return err
+	})
+}
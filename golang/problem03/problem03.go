@@ -2,15 +2,16 @@ package problem03
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
-	"os/signal"
 	"protohackers/utils"
 	"regexp"
+	"sort"
 	"strings"
-	"syscall"
 )
 
 type Session struct {
@@ -24,53 +25,109 @@ type Message struct {
 	Content string
 }
 
-var Ingress chan Session
-var Egress chan string
-var Messages chan Message
+// Room is a chat room whose members are tracked by its Coordinator. Every server has its own.
+type Room struct {
+	Ingress  chan Session
+	Egress   chan string
+	Messages chan Message
+	Members  chan chan []string
+	done     chan struct{}
 
-func Coordinator() {
-	validUsername := regexp.MustCompile(`^[[:alnum:]]{1,16}$`)
+	validUsername *regexp.Regexp
+}
+
+// settings are the chat server's tunables, its [budgetchat] section of the config file.
+type settings struct {
+	UsernameMaxLen int `toml:"username_max_len"`
+}
+
+var defaultSettings = settings{UsernameMaxLen: 16}
+
+func (st settings) Validate() error {
+	switch {
+	case st.UsernameMaxLen < 16:
+		return errors.New("username_max_len must allow at least 16 characters")
+	case st.UsernameMaxLen > 1000:
+		// The most a regexp repetition allows
+		return errors.New("username_max_len can be at most 1000")
+	}
+	return nil
+}
+
+// chatServer is the budget chat TCP server, able to report who is in the room.
+type chatServer struct {
+	*utils.TCPServer
+	room *Room
+}
 
+type roomState struct {
+	Members []string `json:"members"`
+}
+
+func newRoom(st settings) *Room {
+	return &Room{
+		Ingress:  make(chan Session),
+		Egress:   make(chan string),
+		Messages: make(chan Message),
+		Members:  make(chan chan []string),
+		done:     make(chan struct{}),
+
+		validUsername: regexp.MustCompile(fmt.Sprintf(`^[[:alnum:]]{1,%d}$`, st.UsernameMaxLen)),
+	}
+}
+
+// Coordinator relays messages and presence notifications between the members of the room until
+// the room is closed.
+func (r *Room) Coordinator(logger *slog.Logger) {
 	sessions := make(map[string]net.Conn)
 	for {
 		select {
-		case m := <-Messages:
+		case <-r.done:
+			return
+		case m := <-r.Messages:
 			msg := "[" + m.Sender + "] " + m.Content + "\n"
-			log.Print(msg)
+			logger.Debug("message", "user", m.Sender, "content", m.Content)
 			for name, conn := range sessions {
 				if name == m.Sender {
 					continue
 				}
 
 				if _, err := conn.Write([]byte(msg)); err != nil {
-					log.Printf("%s (%v)", err, conn.RemoteAddr())
+					logger.Warn("cannot send to client", "err", err, "remote", conn.RemoteAddr().String())
 				}
 			}
-		case u := <-Egress:
+		case reply := <-r.Members:
+			users := make([]string, 0, len(sessions))
+			for name := range sessions {
+				users = append(users, name)
+			}
+			sort.Strings(users)
+			reply <- users
+		case u := <-r.Egress:
 			delete(sessions, u)
 
 			msg := "* " + u + " has left the room\n"
-			log.Print(msg)
+			logger.Info("user left", "user", u)
 			for _, conn := range sessions {
 				if _, err := conn.Write([]byte(msg)); err != nil {
-					log.Printf("%s (%v)", err, conn.RemoteAddr())
+					logger.Warn("cannot send to client", "err", err, "remote", conn.RemoteAddr().String())
 				}
 			}
-		case s := <-Ingress:
+		case s := <-r.Ingress:
 			if _, exists := sessions[s.Username]; exists {
 				s.errc <- fmt.Errorf("requested username is taken: " + s.Username)
 				break
-			} else if match := validUsername.MatchString(s.Username); !match {
+			} else if match := r.validUsername.MatchString(s.Username); !match {
 				s.errc <- fmt.Errorf("invalid username: " + s.Username)
 				break
 			}
 
 			var users []string
 			msg := "* " + s.Username + " has entered the room\n"
-			log.Print(msg)
+			logger.Info("user joined", "user", s.Username, "remote", s.Conn.RemoteAddr().String())
 			for name, conn := range sessions {
 				if _, err := conn.Write([]byte(msg)); err != nil {
-					log.Printf("%s (%v)", err, conn.RemoteAddr())
+					logger.Warn("cannot send to client", "err", err, "remote", conn.RemoteAddr().String())
 				}
 
 				users = append(users, name)
@@ -81,59 +138,95 @@ func Coordinator() {
 
 			msg = "* The room contains: " + strings.Join(users, ", ") + "\n"
 			if _, err := s.Conn.Write([]byte(msg)); err != nil {
-				log.Printf("%s (%v)", err, s.Conn.RemoteAddr())
+				logger.Warn("cannot send to client", "err", err, "remote", s.Conn.RemoteAddr().String())
 			}
 
 		}
 	}
 }
 
-func Run() {
-	server, err := utils.NewTCPServer(utils.LISTENADDRESS, handleConnection)
+func init() {
+	utils.Register(3, utils.NewServerService("budgetchat", "Budget Chat: line based TCP chat room", New))
+	utils.RegisterDecoder("budgetchat", utils.LineDecoder)
+}
+
+// New returns the budget chat server, listening on the configured address.
+func New(cfg utils.Config) (utils.Server, error) {
+	st := defaultSettings
+	if err := cfg.Settings.Decode(&st); err != nil {
+		return nil, fmt.Errorf("settings: %w", err)
+	}
+	room := newRoom(st)
+	server, err := utils.NewTCPServer(cfg.Address(), room.handleConnection, cfg.TCPOptions()...)
 	if err != nil {
-		fmt.Println("error starting server: ", err)
-		return
+		return nil, err
+	}
+	go room.Coordinator(utils.Logger(cfg.Name))
+	return &chatServer{server, room}, nil
+}
+
+// Stop shuts the server down, then the room's coordinator.
+func (c *chatServer) Stop(ctx context.Context) error {
+	err := c.TCPServer.Stop(ctx)
+	close(c.room.done)
+	return err
+}
+
+// State reports the users currently in the room, none once the server stopped.
+func (c *chatServer) State() any {
+	reply := make(chan []string)
+	select {
+	case c.room.Members <- reply:
+		return roomState{<-reply}
+	case <-c.room.done:
+		return roomState{[]string{}}
 	}
-	Ingress = make(chan Session)
-	Egress = make(chan string)
-	Messages = make(chan Message)
-	go Coordinator()
-	server.Start()
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-
-	fmt.Println("Shutting down server...")
-	server.Stop()
-	fmt.Println("Server stopped.")
 }
 
-func handleConnection(conn net.Conn) {
+func (r *Room) handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
+	logger := utils.LoggerFromContext(ctx)
 	msg := "Welcome to budgetchat! What shall I call you?\n"
 	if _, err := conn.Write([]byte(msg)); err != nil {
-		fmt.Printf("Cannot send to client, error %v\n", err)
+		logger.Warn("cannot send to client", "err", err)
 	}
 	var username string
 	scanner := bufio.NewScanner(conn)
 	if scanner.Scan() {
 		username = scanner.Text()
 		errc := make(chan error)
-		Ingress <- Session{username, conn, errc}
+		utils.CountMessage(ctx, "join")
+		// Once the room is closed, its coordinator is gone: nobody is left to tell
+		select {
+		case r.Ingress <- Session{username, conn, errc}:
+		case <-r.done:
+			return
+		}
 		if err := <-errc; err != nil {
-			log.Printf("%v", err)
+			logger.Info("join refused", "err", err)
 			return
 		}
 		defer func() {
-			Egress <- username
+			select {
+			case r.Egress <- username:
+			case <-r.done:
+			}
 		}()
 	} else {
-		log.Printf("no username provided")
+		logger.Debug("no username provided")
 		return
 	}
 
 	for scanner.Scan() {
-		Messages <- Message{username, scanner.Text()}
+		utils.CountMessage(ctx, "message")
+		select {
+		case r.Messages <- Message{username, scanner.Text()}:
+		case <-r.done:
+			return
+		}
+	}
+	if err := scanner.Err(); errors.Is(err, os.ErrDeadlineExceeded) {
+		logger.Info("client timed out", "user", username)
 	}
 }
 
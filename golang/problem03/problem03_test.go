@@ -0,0 +1,178 @@
+package problem03
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"protohackers/utils/testharness"
+	"testing"
+	"time"
+)
+
+const welcome = "Welcome to budgetchat! What shall I call you?"
+
+// join connects to the room as name and returns the connection after the room listing.
+func join(t *testing.T, addr, name, members string) *testharness.Conn {
+	t.Helper()
+	conn := testharness.Dial(t, "tcp", addr)
+	conn.ExpectLine(welcome)
+	conn.WriteLine(name)
+	conn.ExpectLine("* The room contains: " + members)
+	return conn
+}
+
+func TestChat(t *testing.T) {
+	server := testharness.Start(t, "budgetchat", New)
+	alice := join(t, server.Addr, "alice", "")
+	bob := join(t, server.Addr, "bob", "alice")
+	alice.ExpectLine("* bob has entered the room")
+
+	bob.WriteLine("hello alice")
+	alice.ExpectLine("[bob] hello alice")
+	alice.WriteLine("hi bob")
+	bob.ExpectLine("[alice] hi bob")
+
+	bob.Close()
+	alice.ExpectLine("* bob has left the room")
+}
+
+func TestRoomPerServer(t *testing.T) {
+	first := testharness.Start(t, "budgetchat", New)
+	second := testharness.Start(t, "budgetchat", New)
+	alice := join(t, first.Addr, "alice", "")
+	bob := join(t, second.Addr, "bob", "")
+	// Names are only taken in their own room, and joins only heard there
+	join(t, second.Addr, "alice", "bob")
+	bob.ExpectLine("* alice has entered the room")
+	alice.ExpectSilence(100 * time.Millisecond)
+}
+
+func TestIllegalNames(t *testing.T) {
+	server := testharness.Start(t, "budgetchat", New)
+	alice := join(t, server.Addr, "alice", "")
+	for _, name := range []string{"", "bob smith", "b@b", "abcdefghijklmnopq", "alice"} {
+		conn := testharness.Dial(t, "tcp", server.Addr)
+		conn.ExpectLine(welcome)
+		conn.WriteLine(name)
+		conn.ExpectClosed()
+	}
+	// Nobody else heard of them, and 16 characters are fine
+	join(t, server.Addr, "abcdefghijklmnop", "alice")
+	alice.ExpectLine("* abcdefghijklmnop has entered the room")
+}
+
+func TestUsernameMaxLen(t *testing.T) {
+	cfg := testharness.Config("budgetchat")
+	cfg.Settings = utils.Settings{"username_max_len": "20"}
+	server := testharness.StartConfig(t, cfg, New)
+	join(t, server.Addr, "abcdefghijklmnopqrst", "")
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	conn.ExpectLine(welcome)
+	conn.WriteLine("abcdefghijklmnopqrstu")
+	conn.ExpectClosed()
+
+	for _, maxLen := range []string{"15", "1001"} {
+		cfg.Settings = utils.Settings{"username_max_len": maxLen}
+		if _, err := New(cfg); err == nil {
+			t.Errorf("expected an error for username_max_len = %s", maxLen)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	server := testharness.Start(t, "budgetchat", New)
+	for _, c := range conformance.Cases("budgetchat") {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.Run(server.Addr); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStateAfterStop(t *testing.T) {
+	srv, err := New(testharness.Config("budgetchat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	join(t, srv.(*chatServer).Addr().String(), "alice", "")
+	state := func() string {
+		t.Helper()
+		got := make(chan any, 1)
+		go func() { got <- srv.(utils.StateDumper).State() }()
+		select {
+		case st := <-got:
+			b, _ := json.Marshal(st)
+			return string(b)
+		case <-time.After(2 * time.Second):
+			t.Fatal("State did not return")
+			return ""
+		}
+	}
+	if got := state(); got != `{"members":["alice"]}` {
+		t.Errorf("unexpected state %s", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	srv.Stop(ctx)
+	if got := state(); got != `{"members":[]}` {
+		t.Errorf("unexpected state after Stop %s", got)
+	}
+}
+
+func TestHandlersLeaveClosedRoom(t *testing.T) {
+	room := newRoom(defaultSettings)
+	coordinated := make(chan struct{})
+	go func() {
+		room.Coordinator(slog.Default())
+		close(coordinated)
+	}()
+	// handle serves one client over a pipe, closing finished once the handler returned.
+	handle := func() (*bufio.Reader, net.Conn, chan struct{}) {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		client.SetDeadline(time.Now().Add(2 * time.Second))
+		finished := make(chan struct{})
+		go func() {
+			room.handleConnection(context.Background(), server)
+			close(finished)
+		}()
+		r := bufio.NewReader(client)
+		if line, err := r.ReadString('\n'); err != nil || line != welcome+"\n" {
+			t.Fatalf("expected the welcome, got %q: %v", line, err)
+		}
+		return r, client, finished
+	}
+	waitFor := func(finished chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-finished:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("handler still running after %s in a closed room", what)
+		}
+	}
+
+	r, alice, aliceDone := handle()
+	alice.Write([]byte("alice\n"))
+	if line, err := r.ReadString('\n'); err != nil || line != "* The room contains: \n" {
+		t.Fatalf("expected the room listing, got %q: %v", line, err)
+	}
+	_, bob, bobDone := handle()
+
+	close(room.done)
+	<-coordinated
+	// A member sending a message, then leaving, and a client joining
+	alice.Write([]byte("hello\n"))
+	waitFor(aliceDone, "a message")
+	bob.Write([]byte("bob\n"))
+	waitFor(bobDone, "a join")
+}
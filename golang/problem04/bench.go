@@ -0,0 +1,59 @@
+package problem04
+
+import (
+	"context"
+	"fmt"
+	"protohackers/utils/bench"
+	"strings"
+	"time"
+)
+
+func init() {
+	bench.Register("kv", func() bench.Scenario { return bench.ScenarioFunc(benchClient) })
+}
+
+// benchTimeout is how long a retrieve waits for its answer before the datagram counts as lost.
+const benchTimeout = time.Second
+
+// benchClient inserts a value under its own key and reads it back, over and over. Retrieves
+// answered with an older value are stale answers to earlier requests and skipped.
+func benchClient(ctx context.Context, addr string, id int, rec *bench.Recorder) error {
+	conn, err := bench.Dial(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	key := fmt.Sprintf("bench%d", id)
+	buf := make([]byte, defaultSettings.MaxMessageSize+1)
+	for i := 0; ctx.Err() == nil; i++ {
+		want := fmt.Sprintf("%s=%d", key, i)
+		err := rec.Time(func() error {
+			if _, err := conn.Write([]byte(want)); err != nil {
+				return err
+			}
+			if _, err := conn.Write([]byte(key)); err != nil {
+				return err
+			}
+			conn.SetReadDeadline(time.Now().Add(benchTimeout))
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return err
+				}
+				got := string(buf[:n])
+				if got == want {
+					return nil
+				}
+				// Answers to retrieves that already timed out can still turn up
+				if !strings.HasPrefix(got, key+"=") {
+					return fmt.Errorf("retrieved %q, expected %q", got, want)
+				}
+			}
+		})
+		if err != nil {
+			// A lost datagram doesn't end the session, UDP has none
+			rec.Error(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package problem04
+
+import (
+	"fmt"
+	"protohackers/utils/conformance"
+	"strings"
+	"time"
+)
+
+// inserts are sent in order, then key retrieved. "{key}" stands for a key unique to the run.
+var inserts = []struct {
+	name    string
+	inserts []string
+	key     string
+	want    string
+}{
+	{"spec foo=bar", []string{"{key}=bar"}, "{key}", "{key}=bar"},
+	{"spec foo=bar=baz", []string{"{key}=bar=baz"}, "{key}", "{key}=bar=baz"},
+	{"spec foo=", []string{"{key}="}, "{key}", "{key}="},
+	{"spec foo===", []string{"{key}==="}, "{key}", "{key}==="},
+	{"spec =foo", []string{"=foo"}, "", "=foo"},
+	{"insert updates the value", []string{"{key}=old", "{key}=new"}, "{key}", "{key}=new"},
+	{"arbitrary characters in keys", []string{"{key} with spaces\n=and newlines"}, "{key} with spaces\n", "{key} with spaces\n=and newlines"},
+	{"version can't be modified", []string{"version=hacked"}, "version", ""},
+}
+
+func init() {
+	conformance.RegisterHealthCheck("kv", func(addr string) error {
+		conn, err := conformance.Dial("udp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.Write([]byte("version")); err != nil {
+			return err
+		}
+		got, err := conn.ReadDatagram()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(string(got), "version=") {
+			return fmt.Errorf("expected the version, got %q", got)
+		}
+		return nil
+	})
+	for _, tc := range inserts {
+		tc := tc
+		conformance.Register("kv", conformance.Case{Name: tc.name, Run: func(addr string) error {
+			key := conformance.Unique("key")
+			fill := func(s string) string { return strings.ReplaceAll(s, "{key}", key) }
+			conn, err := conformance.Dial("udp", addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			for _, insert := range tc.inserts {
+				if err := conn.Write([]byte(fill(insert))); err != nil {
+					return err
+				}
+			}
+			// Inserts are not answered
+			if err := conn.ExpectSilence(100 * time.Millisecond); err != nil {
+				return err
+			}
+			if err := conn.Write([]byte(fill(tc.key))); err != nil {
+				return err
+			}
+			datagram, err := conn.ReadDatagram()
+			if err != nil {
+				return err
+			}
+			got := string(datagram)
+			if tc.key == "version" {
+				if got == "version=hacked" || !strings.HasPrefix(got, "version=") || got == "version=" {
+					return fmt.Errorf("expected the version to be unchanged, got %q", got)
+				}
+				return nil
+			}
+			if want := fill(tc.want); got != want {
+				return fmt.Errorf("expected %q, got %q", want, got)
+			}
+			return nil
+		}})
+	}
+	conformance.Register("kv", conformance.Case{Name: "responses under 1000 bytes", Run: func(addr string) error {
+		key := conformance.Unique("key")
+		conn, err := conformance.Dial("udp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.Write([]byte(key + "=" + strings.Repeat("v", 999-len(key)-1))); err != nil {
+			return err
+		}
+		if err := conn.Write([]byte(key)); err != nil {
+			return err
+		}
+		got, err := conn.ReadDatagram()
+		if err != nil {
+			return err
+		}
+		if len(got) >= 1000 {
+			return fmt.Errorf("expected a response under 1000 bytes, got %d", len(got))
+		}
+		return nil
+	}})
+}
@@ -1,60 +1,109 @@
 package problem04
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"protohackers/utils"
 	"strings"
+	"sync"
 )
 
-func Run() {
-	server, err := utils.NewUDPServer(utils.LISTENADDRESS, handleConnection)
-	if err != nil {
-		fmt.Println("error starting server: ", err)
-		return
-	}
-	server.Start()
-	server.Stop()
+func init() {
+	utils.Register(4, utils.NewServerService("kv", "Unusual Database Program: UDP key-value store", New))
 }
 
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
+type (
+	// settings are the key-value store's tunables, its [kv] section of the config file.
+	settings struct {
+		MaxMessageSize int    `toml:"max_message_size"` // Longer requests are dropped
+		Version        string `toml:"version"`          // Answer to "version", which clients can't change
+	}
 
-	udpConn, ok := conn.(*net.UDPConn)
-	if !ok {
-		log.Println("bad connection type")
-		return
+	store struct {
+		mu   sync.Mutex
+		data map[string]string
 	}
 
-	var store = map[string]string{"version": "Ken's Key-Value Store 1.0.0"}
+	// kvServer is the UDP server together with the store it serves.
+	kvServer struct {
+		*utils.UDPServer
+		store *store
+	}
 
-	buf := make([]byte, 1024)
-	for {
-		n, addr, err := udpConn.ReadFrom(buf)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
+	kvState struct {
+		Keys int `json:"keys"`
+	}
+)
 
-		request := string(buf[:n])
-		log.Printf("%v %v", addr, request)
+var defaultSettings = settings{
+	// All requests and responses must be shorter than 1000 bytes. Longer requests are dropped
+	// rather than cut short, which would store or retrieve a different key than the client sent.
+	MaxMessageSize: 999,
+	Version:        "Ken's Key-Value Store 1.0.0",
+}
 
-		k, v, insert := strings.Cut(request, "=")
-		if insert {
-			if k == "version" {
-				continue
-			}
-			store[k] = v
+func (st settings) Validate() error {
+	switch {
+	case st.MaxMessageSize <= 0 || st.MaxMessageSize > 65507:
+		return errors.New("max_message_size must be between 1 and 65507, the largest UDP payload")
+	case len("version=")+len(st.Version) > st.MaxMessageSize:
+		return errors.New("the answer to a version request would be longer than max_message_size")
+	}
+	return nil
+}
 
-		} else {
-			response := fmt.Sprintf("%v=%v", k, store[k])
+// New returns the key-value store server for the configured address.
+func New(cfg utils.Config) (utils.Server, error) {
+	settings := defaultSettings
+	if err := cfg.Settings.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("settings: %w", err)
+	}
+	st := newStore(settings.Version)
+	server, err := utils.NewUDPServer(cfg.Address(), st.handlePacket, append(cfg.UDPOptions(), utils.WithMaxDatagramSize(settings.MaxMessageSize))...)
+	if err != nil {
+		return nil, err
+	}
+	return &kvServer{server, st}, nil
+}
+
+func newStore(version string) *store {
+	return &store{
+		data: map[string]string{"version": version},
+	}
+}
 
-			_, err := udpConn.WriteTo([]byte(response), addr)
-			if err != nil {
-				log.Print(err)
-			}
+// State reports how many keys the store holds, the version included.
+func (kv *kvServer) State() any {
+	kv.store.mu.Lock()
+	defer kv.store.mu.Unlock()
+	return kvState{len(kv.store.data)}
+}
+
+func (st *store) handlePacket(ctx context.Context, pkt []byte, from net.Addr, reply func([]byte) error) {
+	logger := utils.LoggerFromContext(ctx)
+	request := string(pkt)
+	logger.Debug("request", "request", request)
+
+	k, v, insert := strings.Cut(request, "=")
+	if insert {
+		utils.CountMessage(ctx, "insert")
+		if k == "version" {
+			return
 		}
+		st.mu.Lock()
+		st.data[k] = v
+		st.mu.Unlock()
+		return
+	}
+
+	utils.CountMessage(ctx, "retrieve")
+	st.mu.Lock()
+	response := fmt.Sprintf("%v=%v", k, st.data[k])
+	st.mu.Unlock()
+	if err := reply([]byte(response)); err != nil {
+		logger.Warn("cannot send response", "err", err)
 	}
 }
 
@@ -0,0 +1,124 @@
+package problem04
+
+import (
+	"context"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"protohackers/utils/testharness"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInsertAndRetrieve(t *testing.T) {
+	server := testharness.Start(t, "kv", New)
+	conn := testharness.Dial(t, "udp", server.Addr)
+	conn.Write([]byte("foo=bar"))
+	conn.Write([]byte("foo"))
+	if got := string(conn.ReadDatagram()); got != "foo=bar" {
+		t.Errorf("expected foo=bar, got %q", got)
+	}
+	// Inserts don't get an answer
+	conn.Write([]byte("foo=baz"))
+	conn.ExpectSilence(100 * time.Millisecond)
+	conn.Write([]byte("foo"))
+	if got := string(conn.ReadDatagram()); got != "foo=baz" {
+		t.Errorf("expected the updated value foo=baz, got %q", got)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	server := testharness.Start(t, "kv", New)
+	conn := testharness.Dial(t, "udp", server.Addr)
+	conn.Write([]byte("version=hacked"))
+	conn.Write([]byte("version"))
+	if got := string(conn.ReadDatagram()); got != "version=Ken's Key-Value Store 1.0.0" {
+		t.Errorf("expected the version to be unchanged, got %q", got)
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	server := testharness.Start(t, "kv", New)
+	conn := testharness.Dial(t, "udp", server.Addr)
+	// The longest request and answer the spec allows, 999 bytes
+	fits := "big=" + strings.Repeat("v", 995)
+	conn.Write([]byte(fits))
+	conn.Write([]byte("big"))
+	if got := string(conn.ReadDatagram()); got != fits {
+		t.Errorf("expected the 999 byte value back, got %d bytes", len(got))
+	}
+	// A byte more is dropped whole, not stored cut short
+	conn.Write([]byte("big=" + strings.Repeat("w", 996)))
+	conn.Write([]byte("big"))
+	if got := string(conn.ReadDatagram()); got != fits {
+		t.Errorf("expected the 1000 byte insert to be dropped, got %d bytes back", len(got))
+	}
+	conn.Write([]byte(strings.Repeat("k", 1000)))
+	conn.ExpectSilence(100 * time.Millisecond)
+}
+
+func TestSettings(t *testing.T) {
+	cfg := testharness.Config("kv")
+	cfg.Settings = utils.Settings{"version": "Ken's Key-Value Store 2.0.0", "max_message_size": "100"}
+	server := testharness.StartConfig(t, cfg, New)
+	conn := testharness.Dial(t, "udp", server.Addr)
+	conn.Write([]byte("version"))
+	if got := string(conn.ReadDatagram()); got != "version=Ken's Key-Value Store 2.0.0" {
+		t.Errorf("expected the configured version, got %q", got)
+	}
+	// Requests over the maximum size are dropped
+	conn.Write([]byte(strings.Repeat("k", 101)))
+	conn.ExpectSilence(100 * time.Millisecond)
+
+	cfg.Settings = utils.Settings{"max_message_size": "20"}
+	if _, err := New(cfg); err == nil {
+		t.Error("expected an error for a version answer longer than max_message_size")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	server := testharness.Start(t, "kv", New)
+	for _, c := range conformance.Cases("kv") {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.Run(server.Addr); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func FuzzHandlePacket(f *testing.F) {
+	for _, tc := range inserts {
+		for _, insert := range tc.inserts {
+			f.Add([]byte(strings.ReplaceAll(insert, "{key}", "foo")), []byte(strings.ReplaceAll(tc.key, "{key}", "foo")))
+		}
+	}
+	f.Add([]byte("version=hacked"), []byte("version"))
+	f.Fuzz(func(t *testing.T, first, second []byte) {
+		st := newStore(defaultSettings.Version)
+		// What the spec says the store holds
+		model := map[string]string{"version": defaultSettings.Version}
+		for _, pkt := range [][]byte{first, second} {
+			var replies []string
+			st.handlePacket(context.Background(), pkt, nil, func(b []byte) error {
+				replies = append(replies, string(b))
+				return nil
+			})
+			k, v, insert := strings.Cut(string(pkt), "=")
+			if insert {
+				if k != "version" {
+					model[k] = v
+				}
+				if len(replies) != 0 {
+					t.Fatalf("insert %q answered %q", pkt, replies)
+				}
+				continue
+			}
+			if want := k + "=" + model[k]; len(replies) != 1 || replies[0] != want {
+				t.Fatalf("retrieve %q answered %q, expected %q", pkt, replies, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package problem05
+
+import (
+	"fmt"
+	"protohackers/utils/conformance"
+	"strings"
+)
+
+// tony is the address the suite expects addresses to be rewritten to.
+var tony = defaultSettings.Tony
+
+// rewrites are chat messages and what the other users must see of them.
+var rewrites = []struct {
+	name, msg, want string
+}{
+	{"spec example", "Hi alice, please send payment to 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX", "Hi alice, please send payment to " + tony},
+	{"26 characters", "7F1u3wSD5RbOHQmupo9nx4TnhQ", tony},
+	{"33 characters", "pay 7LOrwbDlS8NujgjddyogWgIM93MV5N2VR now", "pay " + tony + " now"},
+	{"35 characters", "7adNeSwJkMakpEcln9HEtthSRtxdmEHOT8T", tony},
+	{"several addresses", "7F1u3wSD5RbOHQmupo9nx4TnhQ or 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX", tony + " or " + tony},
+	{"too short", "7F1u3wSD5RbOHQmupo9nx4Tnh", "7F1u3wSD5RbOHQmupo9nx4Tnh"},
+	{"too long", "7adNeSwJkMakpEcln9HEtthSRtxdmEHOT8Tx", "7adNeSwJkMakpEcln9HEtthSRtxdmEHOT8Tx"},
+	{"not starting with 7", "8F1u3wSD5RbOHQmupo9nx4TnhQ", "8F1u3wSD5RbOHQmupo9nx4TnhQ"},
+	{"not preceded by a space", "x7F1u3wSD5RbOHQmupo9nx4TnhQ", "x7F1u3wSD5RbOHQmupo9nx4TnhQ"},
+	{"not followed by a space", "7F1u3wSD5RbOHQmupo9nx4TnhQ-1234", "7F1u3wSD5RbOHQmupo9nx4TnhQ-1234"},
+	{"product ID", "This is a product ID, not a Boguscoin: 7UR49eZJCGFz8pR3xdxJsOjN9L2lhDnUgH-p8QxjrlbeuzQzUBLsh0LXygtS7SAI-1234",
+		"This is a product ID, not a Boguscoin: 7UR49eZJCGFz8pR3xdxJsOjN9L2lhDnUgH-p8QxjrlbeuzQzUBLsh0LXygtS7SAI-1234"},
+}
+
+// joinThroughProxy joins the chat room behind the proxy as name, reading the room listing.
+func joinThroughProxy(addr, name string) (*conformance.Conn, error) {
+	conn, err := conformance.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := join(conn, name); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// join answers the prompt with name and reads the room listing.
+func join(conn *conformance.Conn, name string) error {
+	if _, err := conn.ReadLine(); err != nil {
+		return err
+	}
+	if err := conn.WriteLine(name); err != nil {
+		return err
+	}
+	line, err := conn.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return fmt.Errorf("expected the room listing, got %q", line)
+	}
+	return nil
+}
+
+// expectFrom skips lines from anybody but sender, returning an error unless the next one from
+// them is want. The upstream room may be busy with other users.
+func expectFrom(conn *conformance.Conn, sender, want string) error {
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "["+sender+"] ") {
+			continue
+		}
+		if line != want {
+			return fmt.Errorf("expected %q, got %q", want, line)
+		}
+		return nil
+	}
+}
+
+// expectNotice skips lines until a notice about name.
+func expectNotice(conn *conformance.Conn, name string) error {
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "*") && strings.Contains(line, name) {
+			return nil
+		}
+	}
+}
+
+func init() {
+	// The prompt comes from upstream, so this checks both sides without joining the room
+	conformance.RegisterHealthCheck("mitm", func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.ReadLine()
+		return err
+	})
+	for _, tc := range rewrites {
+		tc := tc
+		conformance.Register("mitm", conformance.Case{Name: tc.name, Run: func(addr string) error {
+			alice, bob := conformance.Unique("alice"), conformance.Unique("bob")
+			aliceConn, err := joinThroughProxy(addr, alice)
+			if err != nil {
+				return err
+			}
+			defer aliceConn.Close()
+			bobConn, err := joinThroughProxy(addr, bob)
+			if err != nil {
+				return err
+			}
+			defer bobConn.Close()
+			if err := bobConn.WriteLine(tc.msg); err != nil {
+				return err
+			}
+			if err := expectFrom(aliceConn, bob, "["+bob+"] "+tc.want); err != nil {
+				return err
+			}
+			if err := aliceConn.WriteLine(tc.msg); err != nil {
+				return err
+			}
+			return expectFrom(bobConn, alice, "["+alice+"] "+tc.want)
+		}})
+	}
+	conformance.Register("mitm", conformance.Case{Name: "client leaving closes the upstream session", Run: func(addr string) error {
+		alice, bob := conformance.Unique("alice"), conformance.Unique("bob")
+		aliceConn, err := joinThroughProxy(addr, alice)
+		if err != nil {
+			return err
+		}
+		defer aliceConn.Close()
+		bobConn, err := joinThroughProxy(addr, bob)
+		if err != nil {
+			return err
+		}
+		defer bobConn.Close()
+		if err := expectNotice(aliceConn, bob); err != nil {
+			return err
+		}
+		bobConn.Close()
+		// Bob's upstream session ends with his, so alice sees him leave
+		return expectNotice(aliceConn, bob)
+	}})
+}
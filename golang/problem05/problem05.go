@@ -2,35 +2,75 @@ package problem05
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
-	"os"
-	"os/signal"
 	"protohackers/utils"
 	"regexp"
 	"strings"
-	"syscall"
 )
 
-func Run() {
-	server, err := utils.NewTCPServer(utils.LISTENADDRESS, handleConnection)
+func init() {
+	utils.Register(5, utils.NewServerService("mitm", "Mob in the Middle: budget chat proxy rewriting Boguscoin addresses", New))
+	utils.RegisterDecoder("mitm", utils.LineDecoder)
+}
+
+// settings are the proxy's tunables, its [mitm] section of the config file.
+type settings struct {
+	Upstream string `toml:"upstream"` // Budget chat server clients are proxied to
+	Tony     string `toml:"tony"`     // Boguscoin address every other address is rewritten to
+}
+
+var defaultSettings = settings{
+	Upstream: "chat.protohackers.com:16963",
+	Tony:     "7YWHMfk9JZe0LM0g1ZauHuiSxhI",
+}
+
+func (st settings) Validate() error {
+	if _, _, err := net.SplitHostPort(st.Upstream); err != nil {
+		return fmt.Errorf("upstream: %w", err)
+	}
+	// Otherwise Tony's address would itself be rewritten by the other direction
+	if !boguscoin.MatchString(st.Tony) {
+		return fmt.Errorf("tony: %q is not a Boguscoin address", st.Tony)
+	}
+	return nil
+}
+
+// proxy rewrites the Boguscoin addresses of the sessions it relays to the upstream server.
+type proxy struct {
+	upstream string
+	tony     string
+}
+
+// New returns the budget chat proxy, listening on the configured address.
+func New(cfg utils.Config) (utils.Server, error) {
+	st := defaultSettings
+	if err := cfg.Settings.Decode(&st); err != nil {
+		return nil, fmt.Errorf("settings: %w", err)
+	}
+	p := &proxy{upstream: st.Upstream, tony: st.Tony}
+	server, err := utils.NewTCPServer(cfg.Address(), p.handleConnection, cfg.TCPOptions()...)
 	if err != nil {
-		fmt.Println("error starting server: ", err)
-		return
+		return nil, err
 	}
-	server.Start()
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-	fmt.Println("Shutting down server...")
-	server.Stop()
+	return server, nil
 }
 
 var boguscoin = regexp.MustCompile(`^7[a-zA-Z0-9]{25,34}$`)
 
-func relay(dst io.WriteCloser, src io.ReadCloser) {
+// rewrite replaces the Boguscoin addresses of a chat message, without its newline, with Tony's.
+func (p *proxy) rewrite(msg string) string {
+	tokens := make([]string, 0, 8)
+	for _, raw := range strings.Split(msg, " ") {
+		tokens = append(tokens, boguscoin.ReplaceAllString(raw, p.tony))
+	}
+	return strings.Join(tokens, " ")
+}
+
+func (p *proxy) relay(logger *slog.Logger, dst io.WriteCloser, src io.ReadCloser) {
 	defer func() { src.Close(); dst.Close() }()
 
 	for r := bufio.NewReader(src); ; {
@@ -39,29 +79,24 @@ func relay(dst io.WriteCloser, src io.ReadCloser) {
 			return
 		}
 
-		tokens := make([]string, 0, 8)
-		for _, raw := range strings.Split(msg[:len(msg)-1], " ") {
-			t := boguscoin.ReplaceAllString(raw, "7YWHMfk9JZe0LM0g1ZauHuiSxhI")
-			tokens = append(tokens, t)
-		}
-
-		out := strings.Join(tokens, " ") + "\n"
+		out := p.rewrite(msg[:len(msg)-1]) + "\n"
 		if _, err = dst.Write([]byte(out)); err != nil {
-			log.Printf("error in writing: %s", err)
+			logger.Warn("error in writing", "err", err)
 		}
 	}
 }
 
-func handleConnection(conn net.Conn) {
+func (p *proxy) handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
-	upstream, err := net.Dial("tcp", "chat.protohackers.com:16963")
+	logger := utils.LoggerFromContext(ctx)
+	upstream, err := net.Dial("tcp", p.upstream)
 	if err != nil {
-		log.Printf("cannot connect upstream: %s", err)
+		logger.Error("cannot connect upstream", "err", err)
 		conn.Close()
 		return
 	}
-	go relay(conn, upstream)
-	relay(upstream, conn)
+	go p.relay(logger, conn, upstream)
+	p.relay(logger, upstream, conn)
 }
 
 /* 5: Mob in the Middle
@@ -0,0 +1,89 @@
+package problem05
+
+import (
+	"protohackers/problem03"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"protohackers/utils/testharness"
+	"strings"
+	"testing"
+)
+
+// startProxy runs the proxy in front of the chat server at upstream.
+func startProxy(t *testing.T, upstream string) *testharness.Server {
+	cfg := testharness.Config("mitm")
+	cfg.Settings = utils.Settings{"upstream": upstream}
+	return testharness.StartConfig(t, cfg, New)
+}
+
+func TestRewritesAddressesBothWays(t *testing.T) {
+	chat := testharness.Start(t, "budgetchat", func(cfg utils.Config) (utils.Server, error) {
+		return problem03.New(cfg)
+	})
+	proxy := startProxy(t, chat.Addr)
+
+	alice := testharness.Dial(t, "tcp", chat.Addr)
+	alice.ReadLine()
+	alice.WriteLine("alice")
+	alice.ReadLine()
+
+	// The proxy is invisible to bob
+	bob := testharness.Dial(t, "tcp", proxy.Addr)
+	bob.ExpectLine("Welcome to budgetchat! What shall I call you?")
+	bob.WriteLine("bob")
+	bob.ExpectLine("* The room contains: alice")
+	alice.ExpectLine("* bob has entered the room")
+
+	bob.WriteLine("Hi alice, please send payment to 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX")
+	alice.ExpectLine("[bob] Hi alice, please send payment to 7YWHMfk9JZe0LM0g1ZauHuiSxhI")
+	alice.WriteLine("7F1u3wSD5RbOHQmupo9nx4TnhQ is mine, not 7LOrwbDlS8NujgjddyogWgIM93MV5N2VR-x")
+	bob.ExpectLine("[alice] 7YWHMfk9JZe0LM0g1ZauHuiSxhI is mine, not 7LOrwbDlS8NujgjddyogWgIM93MV5N2VR-x")
+
+	// Either side leaving ends the session
+	bob.Close()
+	alice.ExpectLine("* bob has left the room")
+}
+
+func TestConformance(t *testing.T) {
+	chat := testharness.Start(t, "budgetchat", func(cfg utils.Config) (utils.Server, error) {
+		return problem03.New(cfg)
+	})
+	proxy := startProxy(t, chat.Addr)
+	for _, c := range conformance.Cases("mitm") {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.Run(proxy.Addr); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func FuzzRewrite(f *testing.F) {
+	for _, r := range rewrites {
+		f.Add(r.msg)
+	}
+	f.Add("7F1u3wSD5RbOHQmupo9nx4TnhQ 7adNeSwJkMakpEcln9HEtthSRtxdmEHOT8T")
+	f.Fuzz(func(t *testing.T, msg string) {
+		p := &proxy{tony: tony}
+		got := p.rewrite(msg)
+		if again := p.rewrite(got); again != got {
+			t.Fatalf("rewriting %q twice gave %q, then %q", msg, got, again)
+		}
+		// Only whole addresses are replaced, so the message keeps its words and spacing
+		in, out := strings.Split(msg, " "), strings.Split(got, " ")
+		if len(in) != len(out) {
+			t.Fatalf("%q rewritten to %q", msg, got)
+		}
+		for i := range in {
+			want := in[i]
+			if boguscoin.MatchString(in[i]) {
+				want = tony
+			}
+			if out[i] != want {
+				t.Fatalf("%q rewritten to %q", in[i], out[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package problem06
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"protohackers/utils/bench"
+	"sync"
+	"time"
+)
+
+func init() {
+	bench.Register("speed", func() bench.Scenario { return &speedScenario{} })
+}
+
+const (
+	// benchRoads is how many roads the simulated cameras are spread over
+	benchRoads = 10
+	// benchCarInterval is how often each pair of cameras sees a speeding car go by
+	benchCarInterval = 10 * time.Millisecond
+)
+
+// speedScenario makes every tenth client a dispatcher for all roads and the others a pair of
+// cameras ten miles apart, which see a car drive between them at 600 mph. The latency observed
+// is the time from the car passing the second camera to its ticket reaching a dispatcher.
+type speedScenario struct {
+	passed sync.Map // Plate to the time the car passed its second camera
+}
+
+func (s *speedScenario) Client(ctx context.Context, addr string, id int, rec *bench.Recorder) error {
+	if id%10 == 0 {
+		return s.dispatcher(ctx, addr, rec)
+	}
+	return s.cameras(ctx, addr, id)
+}
+
+func (s *speedScenario) dispatcher(ctx context.Context, addr string, rec *bench.Recorder) error {
+	conn, err := bench.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	msg := []byte{byte(TypeIAmDispatcher), benchRoads}
+	for road := uint16(1); road <= benchRoads; road++ {
+		msg = binary.BigEndian.AppendUint16(msg, road)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		msgType, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch MsgType(msgType) {
+		case TypeTicket:
+			plate, err := readStr(r)
+			if err != nil {
+				return err
+			}
+			// road, mile1, timestamp1, mile2, timestamp2, speed
+			if _, err := r.Discard(2 + 2 + 4 + 2 + 4 + 2); err != nil {
+				return err
+			}
+			if passed, ok := s.passed.LoadAndDelete(plate); ok {
+				rec.Observe(time.Since(passed.(time.Time)))
+			} else {
+				rec.Error(fmt.Errorf("unexpected ticket for %q", plate))
+			}
+		case TypeError:
+			msg, _ := readStr(r)
+			return fmt.Errorf("dispatcher got error %q", msg)
+		default:
+			return fmt.Errorf("dispatcher got unexpected message type 0x%02x", msgType)
+		}
+	}
+}
+
+func (s *speedScenario) cameras(ctx context.Context, addr string, id int) error {
+	road := uint16(1 + id%benchRoads)
+	var cams [2]net.Conn
+	for i := range cams {
+		conn, err := bench.Dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		msg := []byte{byte(TypeIAmCamera)}
+		msg = binary.BigEndian.AppendUint16(msg, road)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(10*i)) // mile
+		msg = binary.BigEndian.AppendUint16(msg, 60)           // limit
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		cams[i] = conn
+	}
+
+	ticker := time.NewTicker(benchCarInterval)
+	defer ticker.Stop()
+	// Every car gets its own plate, so no ticket is held back as the car's second of the day
+	for car := 0; ; car++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		plate := fmt.Sprintf("B%dC%d", id, car)
+		timestamp := uint32(1_000_000 + car*60)
+		if err := writePlate(cams[0], plate, timestamp); err != nil {
+			return err
+		}
+		s.passed.Store(plate, time.Now())
+		if err := writePlate(cams[1], plate, timestamp+60); err != nil {
+			return err
+		}
+	}
+}
+
+func writePlate(conn net.Conn, plate string, timestamp uint32) error {
+	msg := []byte{byte(TypePlate), byte(len(plate))}
+	msg = append(msg, plate...)
+	msg = binary.BigEndian.AppendUint32(msg, timestamp)
+	_, err := conn.Write(msg)
+	return err
+}
+
+func readStr(r *bufio.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
@@ -1,6 +1,4 @@
-package problem06 
-
-import "encoding/binary"
+package problem06
 
 type (
 	// Each camera is on a specific road, at a specific location, and has a specific speed limit.
@@ -11,12 +9,17 @@ type (
 	}
 )
 
-func (c *Camera) UnmarshalBinary(msg []byte) {
+func (c *Camera) UnmarshalBinary(msg []byte) error {
+	r := &frameReader{buf: msg}
+	if err := r.checkType(TypeIAmCamera); err != nil {
+		return err
+	}
 	// Fields are ORDERED in data
 	// road: u16
-	c.Road = binary.BigEndian.Uint16(msg[1:3])
+	c.Road = r.u16()
 	// mile: u16
-	c.Mile = binary.BigEndian.Uint16(msg[3:5])
+	c.Mile = r.u16()
 	// limit: u16 (miles per hour)
-	c.Limit = binary.BigEndian.Uint16(msg[5:])
-}
\ No newline at end of file
+	c.Limit = r.u16()
+	return r.done()
+}
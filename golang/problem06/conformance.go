@@ -0,0 +1,267 @@
+package problem06
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"strings"
+	"time"
+)
+
+// quiet is how long a dispatcher waits to be sure no more tickets are coming.
+const quiet = 300 * time.Millisecond
+
+var decoder = utils.DecoderFunc(decodeFrame)
+
+// uniqueRoad returns a road no other scenario uses, so runs against a shared server don't meet.
+func uniqueRoad() uint16 {
+	return uint16(1000 + rand.Intn(64000))
+}
+
+// scenario is one scenario's clients, closed when it ends.
+type scenario struct {
+	addr  string
+	conns []*conformance.Conn
+}
+
+func (sc *scenario) dial() (*conformance.Conn, error) {
+	conn, err := conformance.Dial("tcp", sc.addr)
+	if err != nil {
+		return nil, err
+	}
+	sc.conns = append(sc.conns, conn)
+	return conn, nil
+}
+
+func (sc *scenario) camera(road, mile, limit uint16) (*conformance.Conn, error) {
+	conn, err := sc.dial()
+	if err != nil {
+		return nil, err
+	}
+	msg := []byte{byte(TypeIAmCamera)}
+	msg = binary.BigEndian.AppendUint16(msg, road)
+	msg = binary.BigEndian.AppendUint16(msg, mile)
+	return conn, conn.Write(binary.BigEndian.AppendUint16(msg, limit))
+}
+
+// see has a new camera at mile of road, limited to limit, report plate at timestamp.
+func (sc *scenario) see(road, mile, limit uint16, plate string, timestamp uint32) error {
+	conn, err := sc.camera(road, mile, limit)
+	if err != nil {
+		return err
+	}
+	return sendPlate(conn, plate, timestamp)
+}
+
+func sendPlate(conn *conformance.Conn, plate string, timestamp uint32) error {
+	msg := []byte{byte(TypePlate), byte(len(plate))}
+	msg = append(msg, plate...)
+	return conn.Write(binary.BigEndian.AppendUint32(msg, timestamp))
+}
+
+func (sc *scenario) dispatcher(roads ...uint16) (*conformance.Conn, error) {
+	conn, err := sc.dial()
+	if err != nil {
+		return nil, err
+	}
+	msg := []byte{byte(TypeIAmDispatcher), byte(len(roads))}
+	for _, road := range roads {
+		msg = binary.BigEndian.AppendUint16(msg, road)
+	}
+	return conn, conn.Write(msg)
+}
+
+func (sc *scenario) close() {
+	for _, conn := range sc.conns {
+		conn.Close()
+	}
+}
+
+// speedCase returns a scenario run with clients of its own.
+func speedCase(name string, run func(sc *scenario) error) conformance.Case {
+	return conformance.Case{Name: name, Run: func(addr string) error {
+		sc := &scenario{addr: addr}
+		defer sc.close()
+		return run(sc)
+	}}
+}
+
+// expectTicket returns an error unless the next message is a ticket for car on road.
+func expectTicket(conn *conformance.Conn, car string, road uint16) error {
+	want := fmt.Sprintf("Ticket plate=%q road=%d ", car, road)
+	frame, desc, err := conn.ReadFrame(decoder)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(desc, want) {
+		return fmt.Errorf("expected a ticket for %s on road %d, got %s (% x)", car, road, desc, frame)
+	}
+	return nil
+}
+
+// expectError returns an error unless the server sends an Error and hangs up.
+func expectError(conn *conformance.Conn) error {
+	frame, desc, err := conn.ReadFrame(decoder)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(desc, "Error") {
+		return fmt.Errorf("expected an error, got %s (% x)", desc, frame)
+	}
+	return conn.ExpectClosed()
+}
+
+type sighting struct {
+	mile      uint16
+	timestamp uint32
+}
+
+// sightings are plates seen by cameras at the given miles of a road limited to 60 mph, and the
+// number of tickets the road's dispatcher must get for them.
+var sightings = []struct {
+	name    string
+	seen    []sighting
+	tickets int
+}{
+	{"spec example", []sighting{{8, 0}, {9, 45}}, 1},
+	{"observations in any order", []sighting{{9, 45}, {8, 0}}, 1},
+	{"driving at the limit", []sighting{{0, 0}, {10, 600}}, 0},
+	{"0.5 mph over the limit", []sighting{{0, 0}, {121, 7200}}, 1},
+	{"less than 0.5 mph over the limit", []sighting{{0, 0}, {1204, 72000}}, 0},
+	{"one ticket per day", []sighting{{0, 0}, {10, 300}, {20, 600}}, 1},
+	{"a ticket for each day", []sighting{{0, 0}, {10, 300}, {20, 2 * 86400}, {30, 2*86400 + 300}}, 2},
+	{"tickets spanning days count for both", []sighting{{0, 86000}, {10, 86420}, {20, 86800}}, 1},
+}
+
+// sendThenError returns a scenario where a client sends msg, having identified as a camera
+// first if asked to, and must get an Error for it.
+func sendThenError(name string, asCamera bool, msg []byte) conformance.Case {
+	return speedCase(name, func(sc *scenario) error {
+		var conn *conformance.Conn
+		var err error
+		if asCamera {
+			conn, err = sc.camera(uniqueRoad(), 1, 60)
+		} else {
+			conn, err = sc.dial()
+		}
+		if err != nil {
+			return err
+		}
+		if err := conn.Write(msg); err != nil {
+			return err
+		}
+		return expectError(conn)
+	})
+}
+
+func init() {
+	conformance.RegisterHealthCheck("speed", func(addr string) error {
+		conn, err := conformance.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.Write([]byte{byte(TypeWantHeartbeat), 0, 0, 0, 1}); err != nil {
+			return err
+		}
+		return conn.ExpectFrame(decoder, "Heartbeat")
+	})
+	for _, tc := range sightings {
+		tc := tc
+		conformance.Register("speed", speedCase(tc.name, func(sc *scenario) error {
+			road, car := uniqueRoad(), strings.ToUpper(conformance.Unique("CAR"))
+			for _, s := range tc.seen {
+				if err := sc.see(road, s.mile, 60, car, s.timestamp); err != nil {
+					return err
+				}
+			}
+			conn, err := sc.dispatcher(road)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < tc.tickets; i++ {
+				if err := expectTicket(conn, car, road); err != nil {
+					return err
+				}
+			}
+			return conn.ExpectSilence(quiet)
+		}))
+	}
+	conformance.Register("speed",
+		speedCase("spec example ticket fields", func(sc *scenario) error {
+			road, car := uniqueRoad(), strings.ToUpper(conformance.Unique("UN"))
+			if err := sc.see(road, 8, 60, car, 0); err != nil {
+				return err
+			}
+			if err := sc.see(road, 9, 60, car, 45); err != nil {
+				return err
+			}
+			conn, err := sc.dispatcher(road)
+			if err != nil {
+				return err
+			}
+			return conn.ExpectFrame(decoder, fmt.Sprintf("Ticket plate=%q road=%d mile1=8 timestamp1=0 mile2=9 timestamp2=45 speed=8000", car, road))
+		}),
+		speedCase("tickets wait for a dispatcher", func(sc *scenario) error {
+			road, car := uniqueRoad(), strings.ToUpper(conformance.Unique("LATE"))
+			if err := sc.see(road, 8, 60, car, 0); err != nil {
+				return err
+			}
+			if err := sc.see(road, 9, 60, car, 45); err != nil {
+				return err
+			}
+			time.Sleep(time.Second)
+			conn, err := sc.dispatcher(road)
+			if err != nil {
+				return err
+			}
+			return expectTicket(conn, car, road)
+		}),
+		speedCase("dispatchers for several roads", func(sc *scenario) error {
+			road1, road2, car := uniqueRoad(), uniqueRoad(), strings.ToUpper(conformance.Unique("TWO"))
+			conn, err := sc.dispatcher(road1, road2)
+			if err != nil {
+				return err
+			}
+			if err := sc.see(road2, 8, 60, car, 0); err != nil {
+				return err
+			}
+			if err := sc.see(road2, 9, 60, car, 45); err != nil {
+				return err
+			}
+			return expectTicket(conn, car, road2)
+		}),
+		speedCase("heartbeats", func(sc *scenario) error {
+			conn, err := sc.camera(uniqueRoad(), 1, 60)
+			if err != nil {
+				return err
+			}
+			if err := conn.Write([]byte{byte(TypeWantHeartbeat), 0, 0, 0, 1}); err != nil {
+				return err
+			}
+			for i := 0; i < 3; i++ {
+				if err := conn.ExpectFrame(decoder, "Heartbeat"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+		speedCase("heartbeat interval of 0 means none", func(sc *scenario) error {
+			conn, err := sc.dial()
+			if err != nil {
+				return err
+			}
+			if err := conn.Write([]byte{byte(TypeWantHeartbeat), 0, 0, 0, 0}); err != nil {
+				return err
+			}
+			return conn.ExpectSilence(quiet)
+		}),
+		sendThenError("error: plate from a client that isn't a camera", false, []byte{byte(TypePlate), 4, 'U', 'N', '1', 'X', 0, 0, 0, 0}),
+		sendThenError("error: identifying twice", true, []byte{byte(TypeIAmDispatcher), 1, 0, 1}),
+		sendThenError("error: heartbeats requested twice", false, []byte{byte(TypeWantHeartbeat), 0, 0, 0, 0, byte(TypeWantHeartbeat), 0, 0, 0, 0}),
+		sendThenError("error: server message sent by a client", false, []byte{byte(TypeHeartbeat)}),
+		sendThenError("error: unknown message type", false, []byte{0xee}),
+	)
+}
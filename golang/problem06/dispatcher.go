@@ -1,7 +1,6 @@
 package problem06
 
-import ( 
-	"encoding/binary"
+import (
 	"fmt"
 	"net"
 )
@@ -13,15 +12,19 @@ type (
 	}
 )
 
-func (td *TicketDispatcher) UnmarshalBinary(data []byte) {
-	offset := 1  // First byte is msgType header
-	roadLen := 2 // uint16 2 bytes
-	numRoads := data[offset]
-	for i := 0; i < int(numRoads); i++ {
-		pos := (i + offset) * roadLen
-		road := binary.BigEndian.Uint16(data[pos : pos+roadLen])
-		td.Roads = append(td.Roads, road)
+func (td *TicketDispatcher) UnmarshalBinary(data []byte) error {
+	r := &frameReader{buf: data}
+	if err := r.checkType(TypeIAmDispatcher); err != nil {
+		return err
 	}
+	numRoads := int(r.u8())
+	for i := 0; i < numRoads && !r.short; i++ {
+		td.Roads = append(td.Roads, r.u16())
+	}
+	if r.short {
+		td.Roads = nil
+	}
+	return r.done()
 }
 
 func (td *TicketDispatcher) send(t *Ticket) error {
@@ -30,4 +33,4 @@ func (td *TicketDispatcher) send(t *Ticket) error {
 		return fmt.Errorf("write: %w", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -2,6 +2,7 @@ package problem06
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"time"
@@ -58,23 +59,32 @@ const (
 	TypeHeartbeat     MsgType = 0x41 // (Server->Client)
 	TypeIAmCamera     MsgType = 0x80 // (Client->Server)
 	TypeIAmDispatcher MsgType = 0x81 // (Client->Server)
-	TypeWantMetrics   MsgType = 0x6D // "m"
 )
 
 // Len returns the expected length of the message of the given type. This includes 1 byte for the message type uint8 itself.
+// Messages whose length depends on their second byte need buf to hold it, otherwise Len returns 0.
 func (t MsgType) Len(buf []byte) int {
 	// Message type is the first byte of all messages
 	headerLen := 1
 	switch t {
+	case TypePlate, TypeTicket, TypeIAmDispatcher, TypeError:
+		if len(buf) <= headerLen {
+			return 0
+		}
+	}
+	switch t {
+	case TypeError:
+		// msg: str +1 for str header
+		return headerLen + 1 + int(buf[headerLen])
 	case TypePlate:
 		// Read "plate" str len +1 for str header
-		plateLen := uint8(buf[headerLen]) + 1
+		plateLen := int(buf[headerLen]) + 1
 		timestampLen := 4
-		return headerLen + int(plateLen) + timestampLen
+		return headerLen + plateLen + timestampLen
 	case TypeTicket:
 		// plate: str +1 for str header
-		plateLen := uint8(buf[headerLen]) + 1
-		return headerLen + int(plateLen) +
+		plateLen := int(buf[headerLen]) + 1
+		return headerLen + plateLen +
 			// road: u16
 			2 +
 			// mile1: u16
@@ -100,13 +110,33 @@ func (t MsgType) Len(buf []byte) int {
 		return headerLen + 3*2
 	case TypeIAmDispatcher:
 		// numroads: u8
-		numroads := uint8(buf[headerLen])
+		numroads := int(buf[headerLen])
 		// hsg type byte + numroads byte + roads: [u16]
-		return headerLen + 1 + int(numroads)*2
+		return headerLen + 1 + numroads*2
 	}
 	return 0
 }
 
+func (t MsgType) String() string {
+	switch t {
+	case TypeError:
+		return "Error"
+	case TypePlate:
+		return "Plate"
+	case TypeTicket:
+		return "Ticket"
+	case TypeWantHeartbeat:
+		return "WantHeartbeat"
+	case TypeHeartbeat:
+		return "Heartbeat"
+	case TypeIAmCamera:
+		return "IAmCamera"
+	case TypeIAmDispatcher:
+		return "IAmDispatcher"
+	}
+	return fmt.Sprintf("MsgType(0x%02x)", byte(t))
+}
+
 func ParseType(raw byte) (MsgType, error) {
 	switch raw {
 	case byte(TypeError):
@@ -123,20 +153,11 @@ func ParseType(raw byte) (MsgType, error) {
 		return TypeIAmCamera, nil
 	case byte(TypeIAmDispatcher):
 		return TypeIAmDispatcher, nil
-	case byte(TypeWantMetrics):
-		return TypeWantMetrics, nil
 	default:
 		return TypeError, fmt.Errorf("invalid message type: %x", raw)
 	}
 }
 
-// ParseTimestamp interprets a byte slice of of a uint32 as a UNIX timestamp.
-func parseTimestamp(data []byte) time.Time {
-	// Timestamps are exactly the same as Unix timestamps (counting seconds since 1st of January 1970), except that they are unsigned.
-	ts := binary.BigEndian.Uint32(data)
-	return time.Unix(int64(ts), 0)
-}
-
 func (u UnixTime) Time() time.Time {
 	return time.Unix(int64(u), 0)
 }
@@ -146,11 +167,85 @@ func (u UnixTime) Day() float64 {
 	return math.Floor(float64(u) / 86400)
 }
 
-func (p *Plate) UnmarshalBinary(msg []byte) {
-	offset := 2 // msg type + data type (str) headers
-	plateLen := uint8(msg[1])
-	p.Plate = string(msg[offset : plateLen+uint8(offset)])
-	p.Timestamp = parseTimestamp(msg[2+plateLen:])
+// frameReader reads the fields of a message, remembering if it ran out of bytes.
+type frameReader struct {
+	buf   []byte
+	pos   int
+	short bool
+}
+
+func (r *frameReader) next(n int) []byte {
+	if r.short || r.pos+n > len(r.buf) {
+		r.short = true
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *frameReader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *frameReader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *frameReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *frameReader) str() string {
+	return string(r.next(int(r.u8())))
+}
+
+// errShortMessage is returned when a message ends before all of its fields.
+var errShortMessage = errors.New("message too short")
+
+// checkType returns an error unless the reader is at a message of type t.
+func (r *frameReader) checkType(t MsgType) error {
+	if got := MsgType(r.u8()); !r.short && got != t {
+		return fmt.Errorf("expected a %s message, got %s", t, got)
+	}
+	return nil
+}
+
+// done returns errShortMessage if the reader ran out of bytes.
+func (r *frameReader) done() error {
+	if r.short {
+		return errShortMessage
+	}
+	return nil
+}
+
+func (p *Plate) UnmarshalBinary(msg []byte) error {
+	r := &frameReader{buf: msg}
+	if err := r.checkType(TypePlate); err != nil {
+		return err
+	}
+	p.Plate = r.str()
+	// Timestamps are exactly the same as Unix timestamps (counting seconds since 1st of January 1970), except that they are unsigned.
+	p.Timestamp = time.Unix(int64(r.u32()), 0)
+	return r.done()
+}
+
+func (e *Error) MarshalBinary() []byte {
+	msg := e.Msg
+	if len(msg) > 255 {
+		msg = msg[:255]
+	}
+	data := []byte{byte(TypeError), byte(len(msg))}
+	return append(data, msg...)
 }
 
 func (t *Ticket) MarshalBinary() []byte {
@@ -170,6 +265,22 @@ func (t *Ticket) MarshalBinary() []byte {
 	return data
 }
 
+// UnmarshalBinary parses a Ticket message as sent to dispatchers.
+func (t *Ticket) UnmarshalBinary(msg []byte) error {
+	r := &frameReader{buf: msg}
+	if err := r.checkType(TypeTicket); err != nil {
+		return err
+	}
+	t.Plate = r.str()
+	t.Road = r.u16()
+	t.Mile1 = r.u16()
+	t.Timestamp1 = UnixTime(r.u32())
+	t.Mile2 = r.u16()
+	t.Timestamp2 = UnixTime(r.u32())
+	t.Speed = r.u16()
+	return r.done()
+}
+
 // IncAttempts increments the ticket's retry counter.
 func (t *Ticket) IncAttempts() {
 	t.retries++
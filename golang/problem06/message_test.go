@@ -0,0 +1,164 @@
+package problem06
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// specMessages are the examples of every message type in the problem statement.
+var specMessages = [][]byte{
+	{0x10, 0x03, 0x62, 0x61, 0x64},
+	{0x10, 0x0b, 0x69, 0x6c, 0x6c, 0x65, 0x67, 0x61, 0x6c, 0x20, 0x6d, 0x73, 0x67},
+	{0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x03, 0xe8},
+	{0x20, 0x07, 0x52, 0x45, 0x30, 0x35, 0x42, 0x4b, 0x47, 0x00, 0x01, 0xe2, 0x40},
+	{0x21, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x42, 0x00, 0x64, 0x00, 0x01, 0xe2, 0x40, 0x00, 0x6e, 0x00, 0x01, 0xe3, 0xa8, 0x27, 0x10},
+	{0x21, 0x07, 0x52, 0x45, 0x30, 0x35, 0x42, 0x4b, 0x47, 0x01, 0x70, 0x04, 0xd2, 0x00, 0x0f, 0x42, 0x40, 0x04, 0xd3, 0x00, 0x0f, 0x42, 0x7c, 0x17, 0x70},
+	{0x40, 0x00, 0x00, 0x00, 0x0a},
+	{0x40, 0x00, 0x00, 0x04, 0xdb},
+	{0x41},
+	{0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c},
+	{0x80, 0x01, 0x70, 0x04, 0xd2, 0x00, 0x28},
+	{0x81, 0x01, 0x00, 0x42},
+	{0x81, 0x03, 0x00, 0x42, 0x01, 0x70, 0x13, 0x88},
+}
+
+func addSpecMessages(f *testing.F) {
+	for _, msg := range specMessages {
+		f.Add(msg)
+	}
+}
+
+// parse parses msg with the parser of its type, if it has one.
+func parse(msg []byte) (bool, error) {
+	switch MsgType(msg[0]) {
+	case TypePlate:
+		return true, new(Plate).UnmarshalBinary(msg)
+	case TypeTicket:
+		return true, new(Ticket).UnmarshalBinary(msg)
+	case TypeIAmCamera:
+		return true, new(Camera).UnmarshalBinary(msg)
+	case TypeIAmDispatcher:
+		return true, new(TicketDispatcher).UnmarshalBinary(msg)
+	}
+	return false, nil
+}
+
+// FuzzMsgTypeLen checks the length the server reads for a message is exactly what its parser
+// needs.
+func FuzzMsgTypeLen(f *testing.F) {
+	addSpecMessages(f)
+	// A plate of 255 characters used to overflow the uint8 length
+	f.Add(append([]byte{0x20, 0xff}, make([]byte, 255+4)...))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		if len(msg) == 0 {
+			return
+		}
+		n := MsgType(msg[0]).Len(msg)
+		if n <= 0 || n > len(msg) {
+			return
+		}
+		if ok, err := parse(msg[:n]); ok && err != nil {
+			t.Fatalf("Len gave %d bytes for % x, not enough to parse: %v", n, msg[:n], err)
+		}
+		if n == 1 {
+			return
+		}
+		if ok, err := parse(msg[:n-1]); ok && err == nil {
+			t.Fatalf("Len gave %d bytes for % x, but %d are enough to parse", n, msg[:n], n-1)
+		}
+	})
+}
+
+func FuzzPlateUnmarshalBinary(f *testing.F) {
+	addSpecMessages(f)
+	f.Add(append([]byte{0x20, 0xff}, make([]byte, 255+4)...))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		var p Plate
+		if err := p.UnmarshalBinary(msg); err != nil {
+			return
+		}
+		// Parsed plates encode back to the same bytes
+		enc := []byte{byte(TypePlate), byte(len(p.Plate))}
+		enc = append(enc, p.Plate...)
+		enc = binary.BigEndian.AppendUint32(enc, uint32(p.Timestamp.Unix()))
+		if !bytes.HasPrefix(msg, enc) {
+			t.Fatalf("% x parsed as %+v", msg, p)
+		}
+	})
+}
+
+func FuzzCameraUnmarshalBinary(f *testing.F) {
+	addSpecMessages(f)
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		var c Camera
+		if err := c.UnmarshalBinary(msg); err != nil {
+			return
+		}
+		if len(msg) < 7 || c.Road != binary.BigEndian.Uint16(msg[1:]) || c.Limit != binary.BigEndian.Uint16(msg[5:]) {
+			t.Fatalf("% x parsed as %+v", msg, c)
+		}
+	})
+}
+
+func FuzzTicketDispatcherUnmarshalBinary(f *testing.F) {
+	addSpecMessages(f)
+	// Claiming more roads than it sends
+	f.Add([]byte{0x81, 0xff, 0x00, 0x42})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		var td TicketDispatcher
+		if err := td.UnmarshalBinary(msg); err != nil {
+			if len(td.Roads) != 0 {
+				t.Fatalf("% x failed to parse but left roads %v", msg, td.Roads)
+			}
+			return
+		}
+		if len(td.Roads) != int(msg[1]) {
+			t.Fatalf("% x parsed as %d roads", msg, len(td.Roads))
+		}
+		for i, road := range td.Roads {
+			if road != binary.BigEndian.Uint16(msg[2+2*i:]) {
+				t.Fatalf("% x parsed as roads %v", msg, td.Roads)
+			}
+		}
+	})
+}
+
+func FuzzTicketRoundTrip(f *testing.F) {
+	f.Add("UN1X", uint16(66), uint16(100), uint32(123456), uint16(110), uint32(123816), uint16(10000))
+	f.Add("RE05BKG", uint16(368), uint16(1234), uint32(1000000), uint16(1235), uint32(1000060), uint16(6000))
+	f.Fuzz(func(t *testing.T, plate string, road, mile1 uint16, ts1 uint32, mile2 uint16, ts2 uint32, speed uint16) {
+		if len(plate) > 255 {
+			return
+		}
+		want := Ticket{Plate: plate, Road: road, Mile1: mile1, Timestamp1: UnixTime(ts1), Mile2: mile2, Timestamp2: UnixTime(ts2), Speed: speed}
+		msg := want.MarshalBinary()
+		if n := TypeTicket.Len(msg); n != len(msg) {
+			t.Fatalf("ticket of %d bytes, Len says %d", len(msg), n)
+		}
+		var got Ticket
+		if err := got.UnmarshalBinary(msg); err != nil {
+			t.Fatalf("cannot parse % x: %v", msg, err)
+		}
+		if got != want {
+			t.Fatalf("%+v came back as %+v", want, got)
+		}
+	})
+}
+
+func TestPlateOfMaximumLength(t *testing.T) {
+	plate := string(bytes.Repeat([]byte{'A'}, 255))
+	msg := append([]byte{byte(TypePlate), 255}, plate...)
+	msg = binary.BigEndian.AppendUint32(msg, 1000)
+	if n := TypePlate.Len(msg); n != len(msg) {
+		t.Fatalf("expected a length of %d, got %d", len(msg), n)
+	}
+	var p Plate
+	if err := p.UnmarshalBinary(msg); err != nil {
+		t.Fatal(err)
+	}
+	if p.Plate != plate || !p.Timestamp.Equal(time.Unix(1000, 0)) {
+		t.Errorf("unexpected plate %+v", p)
+	}
+}
@@ -0,0 +1,35 @@
+package problem06
+
+import "protohackers/utils/metrics"
+
+var (
+	platesSeen   = metrics.NewCounterVec("speed_plates_total", "Plate observations received from cameras.", "problem")
+	platesUnique = metrics.NewCounterVec("speed_plates_unique_total", "Plates observed for the first time on a road.", "problem")
+	ticketEvents = metrics.NewCounterVec("speed_tickets_total", "Ticket dispatch events: queued, attempt, requeued, held, issued, failed and dropped.", "problem", "event")
+)
+
+type speedMetrics struct {
+	platesTotal  *metrics.Counter
+	platesUnique *metrics.Counter
+	issued       *metrics.Counter
+	queued       *metrics.Counter
+	failed       *metrics.Counter
+	attempts     *metrics.Counter
+	requeued     *metrics.Counter
+	dropped      *metrics.Counter
+	held         *metrics.Counter
+}
+
+func newSpeedMetrics(problem string) speedMetrics {
+	return speedMetrics{
+		platesTotal:  platesSeen.With(problem),
+		platesUnique: platesUnique.With(problem),
+		issued:       ticketEvents.With(problem, "issued"),
+		queued:       ticketEvents.With(problem, "queued"),
+		failed:       ticketEvents.With(problem, "failed"),
+		attempts:     ticketEvents.With(problem, "attempt"),
+		requeued:     ticketEvents.With(problem, "requeued"),
+		dropped:      ticketEvents.With(problem, "dropped"),
+		held:         ticketEvents.With(problem, "held"),
+	}
+}
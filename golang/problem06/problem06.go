@@ -4,43 +4,38 @@ import (
 	"bufio"
 	"context"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
-	"os"
-	"os/signal"
+	"protohackers/utils"
 	"sync"
-	"syscall"
 	"time"
 )
 
 type (
 	Server struct {
-		listener    net.Listener
+		server      *utils.TCPServer
+		cancel      context.CancelFunc // stops the ticket dispatch loop
+		logger      *slog.Logger
 		mu          sync.Mutex
 		dispatchers map[uint16]map[*TicketDispatcher]bool // [road ID]:dispatcher
 		plates      map[uint16]map[string][]*observation  // [road ID][plate]
 		ticketQueue ticketQueue
+		waiting     map[uint16][]*Ticket // [road ID]: tickets held until a dispatcher connects
 		ih          issueHistory
-		metrics     metrics
+		metrics     speedMetrics
+		settings    settings
+		// With client certificate verification configured, only verified clients may dispatch
+		dispatcherNeedsCert bool
 	}
 
-	metrics struct {
-		Plates struct {
-			Total  int
-			Unique int
-		}
-		Tickets struct {
-			Issued   int
-			Queued   int
-			Failed   int
-			Attempts int
-			Requeued int
-			Dropped  int
-		}
+	// settings are the speed daemon's tunables, its [speed] section of the config file.
+	settings struct {
+		TicketQueueSize  int           `toml:"ticket_queue_size"` // Tickets waiting for dispatch
+		DispatchAttempts int           `toml:"dispatch_attempts"` // Attempts before a ticket is held for its road's first dispatcher
+		DispatchDelay    time.Duration `toml:"dispatch_delay"`    // Pause before each attempt
 	}
 
 	issueHistory interface {
@@ -51,6 +46,13 @@ type (
 		printHistory(plate string) string
 	}
 
+	speedState struct {
+		DispatchersPerRoad map[uint16]int `json:"dispatchers_per_road"`
+		PlatesPerRoad      map[uint16]int `json:"plates_per_road"`
+		TicketQueueDepth   int            `json:"ticket_queue_depth"`
+		TicketsHeld        int            `json:"tickets_held"`
+	}
+
 	// Observation represents an event when a car's plate was captured on a certain road at a specific time and location.
 	observation struct {
 		plate     string
@@ -58,106 +60,194 @@ type (
 		timestamp time.Time
 	}
 
-	ctxKey string
-
 	ClientError struct {
 		Err error
 	}
 )
 
-const CONNECTION_ID ctxKey = "CONNECTION_ID"
+func init() {
+	utils.Register(6, utils.NewServerService("speed", "Speed Daemon: average speed cameras issuing tickets to dispatchers", New))
+}
+
+var defaultSettings = settings{
+	TicketQueueSize:  8192,
+	DispatchAttempts: 50,
+	DispatchDelay:    time.Millisecond,
+}
 
-func Run() {
-	port := "9999"
-	srv := Server{
+func (st settings) Validate() error {
+	switch {
+	case st.TicketQueueSize <= 0:
+		return errors.New("ticket_queue_size must be positive")
+	case st.DispatchAttempts <= 0:
+		return errors.New("dispatch_attempts must be positive")
+	case st.DispatchDelay < 0:
+		return errors.New("dispatch_delay can not be negative")
+	}
+	return nil
+}
+
+// New returns the speed daemon, listening on the configured address.
+func New(cfg utils.Config) (utils.Server, error) {
+	st := defaultSettings
+	if err := cfg.Settings.Decode(&st); err != nil {
+		return nil, fmt.Errorf("settings: %w", err)
+	}
+	s := &Server{
 		dispatchers: make(map[uint16]map[*TicketDispatcher]bool, 0),
 		plates:      make(map[uint16]map[string][]*observation, 0),
-		ticketQueue: make(ticketQueue, 8192),
+		ticketQueue: make(ticketQueue, st.TicketQueueSize),
+		waiting:     make(map[uint16][]*Ticket),
 		ih:          newHistory(),
-	}
-	if err := srv.Start(context.Background(), port); err != nil {
-		log.Fatal(err)
-	}
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-	fmt.Println("Shutting down server...")
-}
+		logger:      utils.Logger(cfg.Name),
+		metrics:     newSpeedMetrics(cfg.Name),
+		settings:    st,
 
-func (s *Server) Start(ctx context.Context, port string) error {
-	l, err := net.Listen("tcp", ":"+port)
+		dispatcherNeedsCert: cfg.TLS != nil && cfg.TLS.ClientCAs != nil,
+	}
+	server, err := utils.NewTCPServer(cfg.Address(), s.serve, cfg.TCPOptions()...)
 	if err != nil {
-		return fmt.Errorf("listen: %w", err)
+		return nil, fmt.Errorf("listen: %w", err)
 	}
+	s.server = server
+	return s, nil
+}
 
-	log.Printf("Speed Daemon listening @ %s", l.Addr().String())
+// Start serves connections and dispatches tickets in the background until Stop is called.
+func (s *Server) Start() error {
+	s.logger.Info("Speed Daemon listening", "addr", s.server.Addr().String())
 
-	s.listener = l
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
 
 	go s.ticketListen(ctx)
+	return s.server.Start()
+}
+
+// Stop stops the ticket dispatch loop and shuts the TCP server down.
+func (s *Server) Stop(ctx context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return s.server.Stop(ctx)
+}
 
-	clientID := 0
+// Drain stops accepting connections and keeps serving the cameras and dispatchers already
+// connected. Once only dispatchers are left and no ticket is queued or held, they are
+// disconnected so they reconnect to the process taking over, instead of leaving tickets behind.
+// Tickets held for roads without a dispatcher keep the process draining until ctx is done, then
+// are lost: new dispatchers only reach the process taking over.
+//
+// Observations aren't handed over either: a car seen by a camera of each process is never
+// checked against both, so it can't get a ticket for that stretch.
+func (s *Server) Drain(ctx context.Context) error {
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.server.Drain(ctx)
+	}()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	// A ticket being dispatched is briefly out of the queue, so wait for two idle ticks in a row
+	idle := 0
 	for {
-		conn, err := l.Accept()
-		if err != nil {
-			return fmt.Errorf("accept: %w", err)
+		select {
+		case err := <-drained:
+			if s.cancel != nil {
+				s.cancel()
+			}
+			if held := s.heldTickets(); held > 0 {
+				s.logger.Warn("tickets lost with the drained process", "held", held)
+			}
+			return err
+		case <-ticker.C:
+			dispatchers := s.connectedDispatchers()
+			if len(s.ticketQueue) > 0 || s.heldTickets() > 0 || len(dispatchers) < len(s.server.Conns()) {
+				idle = 0
+				continue
+			}
+			if idle++; idle == 2 {
+				s.logger.Info("handing dispatchers over", "dispatchers", len(dispatchers))
+				for _, td := range dispatchers {
+					td.conn.Close()
+				}
+			}
 		}
+	}
+}
 
-		clientID++
-		go func(conn net.Conn, clientID int) {
-			ctx := context.WithValue(ctx, ctxKey(CONNECTION_ID), fmt.Sprintf("%d", clientID))
+// heldTickets counts the tickets held for roads without a dispatcher.
+func (s *Server) heldTickets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := 0
+	for _, tickets := range s.waiting {
+		held += len(tickets)
+	}
+	return held
+}
 
-			if err := s.HandleConnection(ctx, conn); err != nil {
-				log.Printf("client [%d] cause error:\n%v\nclosing connection..", clientID, err)
-				if err := conn.Close(); err != nil {
-					log.Printf("close: %x\n", err)
-				}
+// connectedDispatchers returns every registered dispatcher once.
+func (s *Server) connectedDispatchers() []*TicketDispatcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[*TicketDispatcher]bool)
+	var all []*TicketDispatcher
+	for _, dispatchers := range s.dispatchers {
+		for td := range dispatchers {
+			if !seen[td] {
+				seen[td] = true
+				all = append(all, td)
 			}
+		}
+	}
+	return all
+}
 
-		}(conn, clientID)
-
-		select {
-		case <-ctx.Done():
-			log.Printf("cancelled with err: %v", ctx.Err())
-			l.Close()
-		default:
-			continue
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	if err := s.HandleConnection(ctx, conn); err != nil {
+		logger := utils.LoggerFromContext(ctx)
+		logger.Warn("client caused error, closing connection", "err", err)
+		if err := conn.Close(); err != nil {
+			logger.Debug("close", "err", err)
 		}
 	}
 }
 
 func (s *Server) HandleConnection(ctx context.Context, conn net.Conn) error {
-	// Identify the client
-	clientID := ctx.Value(CONNECTION_ID)
 	err := s.addClient(ctx, conn)
 	if err != nil {
 		var clientErr *ClientError
 		switch {
 		case errors.As(err, &clientErr):
-			// TODO: Marshall message.Error and send back to client
+			utils.LoggerFromContext(ctx).Info("client error", "err", err)
+			if _, err := conn.Write((&Error{clientErr.Error()}).MarshalBinary()); err != nil {
+				utils.LoggerFromContext(ctx).Debug("cannot send error", "err", err)
+			}
 		default: // Server Error
-			if !errors.Is(err, io.EOF) {
-				log.Printf("[%s] Conn ERR: %v", clientID, err)
+			// Closed connections were kicked or handed over while draining
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				utils.LoggerFromContext(ctx).Warn("connection error", "err", err)
 			}
 		}
-		return conn.Close()
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		return nil
 	}
 	return nil
 }
 
 // AddClient identifies a client from it's message type and add them to the appropriate client bucket (cams or dispatchers).
 func (s *Server) addClient(ctx context.Context, conn net.Conn) error {
-	clientID := ctx.Value(CONNECTION_ID)
+	logger := utils.LoggerFromContext(ctx)
+	// Ends the client's heartbeat along with the connection
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	// Client will be a cam or a dispatcher
 	var meCam Camera
 	var dispatcher TicketDispatcher
-	var heartbeatTicker *time.Ticker
-	defer func() {
-		if heartbeatTicker != nil {
-			heartbeatTicker.Stop()
-		}
-		s.unregisterDispatcher(ctx, &dispatcher)
-	}()
+	var isCamera, isDispatcher, wantsHeartbeat bool
+	defer s.unregisterDispatcher(ctx, &dispatcher)
 
 	r := bufio.NewReader(conn)
 
@@ -169,22 +259,16 @@ func (s *Server) addClient(ctx context.Context, conn net.Conn) error {
 		// Read the first byte to get the message type
 		msgType, err := ParseType(msgHdr[0])
 		if err != nil {
-			invalidMsg, err := r.Peek(10)
-			if err != nil {
-				log.Printf("problem peek invalid message: %v", err)
-			}
-			log.Printf("invalid message type: %v\n%x", err, invalidMsg)
-			return &ClientError{fmt.Errorf("invalid message type: %w", err)}
+			// Only log what already arrived, the client may never send more
+			invalidMsg, _ := r.Peek(r.Buffered())
+			logger.Info("invalid message type", "msg", fmt.Sprintf("% x", invalidMsg))
+			return &ClientError{err}
 		}
 
-		if msgType == TypeWantMetrics {
-			if err := json.NewEncoder(conn).Encode(s.metrics); err != nil {
-				return fmt.Errorf("error sending metrics response: %w", err)
-			}
-			if _, err := r.Discard(r.Buffered()); err != nil {
-				return fmt.Errorf("discard: %w", err)
-			}
-			continue
+		switch msgType {
+		case TypeError, TypeTicket, TypeHeartbeat:
+			// Only the server sends these
+			return &ClientError{fmt.Errorf("illegal message type: %s", msgType)}
 		}
 
 		// Calc the expected length of the message.
@@ -200,73 +284,139 @@ func (s *Server) addClient(ctx context.Context, conn net.Conn) error {
 		n, err := io.ReadFull(r, msg)
 		if err != nil {
 			if err == io.ErrUnexpectedEOF {
-				log.Printf("[%s]** expected to read %d bytes, but only recv'd: %d\nmsg: %x", clientID, msgLen, n, msg)
+				logger.Info("short message", "expected", msgLen, "received", n, "msg", fmt.Sprintf("% x", msg[:n]))
 			}
 			return fmt.Errorf("read: %w", err)
 		}
 
 		// Handle message
+		utils.CountMessage(ctx, msgType.String())
 		switch msgType {
 		case TypeIAmCamera:
-			meCam.UnmarshalBinary(msg)
-			// log.Printf("[%s]TypeIAmCamera: %+v\nraw: %x", clientID, meCam, msg)
+			if isCamera || isDispatcher {
+				return &ClientError{errors.New("client already identified itself")}
+			}
+			isCamera = true
+			if err := meCam.UnmarshalBinary(msg); err != nil {
+				return &ClientError{err}
+			}
+			logger.Debug("IAmCamera", "road", meCam.Road, "mile", meCam.Mile, "limit", meCam.Limit)
 		case TypeIAmDispatcher:
+			if isCamera || isDispatcher {
+				return &ClientError{errors.New("client already identified itself")}
+			}
+			isDispatcher = true
+			if s.dispatcherNeedsCert && !utils.ClientVerified(ctx) {
+				logger.Info("refusing dispatcher without a verified client certificate")
+				return &ClientError{errors.New("dispatchers must present a verified client certificate")}
+			}
 			dispatcher.conn = conn
-			s.registerDispatcher(ctx, msg, &dispatcher)
-			// log.Printf("[%s]TypeIAmDispatcher: %+v\n%x", clientID, dispatcher, msg)
+			if err := s.registerDispatcher(ctx, msg, &dispatcher); err != nil {
+				return &ClientError{err}
+			}
+			logger.Debug("IAmDispatcher", "roads", dispatcher.Roads)
 		case TypePlate:
-			// log.Printf("[%s]TypePlate: %x", clientID, msg)
-			s.handlePlate(ctx, msg, meCam)
+			if !isCamera {
+				return &ClientError{errors.New("only cameras may send plates")}
+			}
+			if err := s.handlePlate(ctx, msg, meCam); err != nil {
+				return &ClientError{err}
+			}
 		case TypeWantHeartbeat:
-			// log.Printf("[%s]TypeWantHeartbeat: %x", clientID, msg)
-			if heartbeatTicker != nil {
+			logger.Debug("WantHeartbeat", "interval", binary.BigEndian.Uint32(msg[1:]))
+			if wantsHeartbeat {
 				return &ClientError{errors.New("wantHeartbeat already sent")}
 			}
-			if err := s.startHeartbeat(ctx, msg, conn, heartbeatTicker); err != nil {
-				return fmt.Errorf("startHeartbeat: %w", err)
-			}
+			wantsHeartbeat = true
+			s.startHeartbeat(ctx, msg, conn)
 		}
 	}
 }
 
 func (s *Server) registerDispatcher(ctx context.Context, msg []byte, td *TicketDispatcher) error {
-	td.UnmarshalBinary(msg)
+	if err := td.UnmarshalBinary(msg); err != nil {
+		return err
+	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	var held []*Ticket
 	for _, rid := range td.Roads {
 		_, ok := s.dispatchers[rid]
 		if !ok {
 			s.dispatchers[rid] = make(map[*TicketDispatcher]bool, 0)
 		}
 		s.dispatchers[rid][td] = true
+		held = append(held, s.waiting[rid]...)
+		delete(s.waiting, rid)
+	}
+	s.mu.Unlock()
+
+	// Tickets held for these roads can go out now
+	for _, ticket := range held {
+		s.metrics.requeued.Inc()
+		select {
+		case s.ticketQueue <- ticket:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return nil
 }
 
+// holdTicket keeps a ticket nobody could be found to dispatch in its road's pending slice, until
+// a dispatcher for the road connects. It returns false, holding nothing, if one connected in the
+// meantime.
+func (s *Server) holdTicket(ticket *Ticket) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.dispatchers[ticket.Road]) > 0 {
+		return false
+	}
+	s.metrics.held.Inc()
+	s.logger.Info("no dispatcher found, holding ticket", "retries", ticket.Retries(), "plate", ticket.Plate, "road", ticket.Road)
+	s.waiting[ticket.Road] = append(s.waiting[ticket.Road], ticket)
+	return true
+}
+
 func (s *Server) unregisterDispatcher(ctx context.Context, td *TicketDispatcher) {
 	if td == nil {
 		return
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for _, rid := range td.Roads {
 		delete(s.dispatchers[rid], td)
 	}
 }
 
-func (s *Server) handlePlate(ctx context.Context, msg []byte, cam Camera) {
+func (s *Server) handlePlate(ctx context.Context, msg []byte, cam Camera) error {
 	p := Plate{}
-	p.UnmarshalBinary(msg)
+	if err := p.UnmarshalBinary(msg); err != nil {
+		return err
+	}
+
+	utils.LoggerFromContext(ctx).Debug("Plate", "plate", p.Plate, "timestamp", p.Timestamp.Unix())
 
-	clientID := ctx.Value(CONNECTION_ID)
-	log.Printf("[%s] Plate: %+v", clientID, p)
+	if v := s.observe(ctx, p, cam); v != nil {
+		// Outside of s.mu: the dispatch loop needs it to make room in a full queue
+		s.metrics.queued.Inc()
+		select {
+		case s.ticketQueue <- v:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
 
+// observe records a plate seen by a camera, returning the ticket it makes, if any.
+func (s *Server) observe(ctx context.Context, p Plate, cam Camera) *Ticket {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if _, ok := s.plates[cam.Road]; !ok {
 		s.plates[cam.Road] = make(map[string][]*observation)
 	}
 
-	s.metrics.Plates.Total++
+	s.metrics.platesTotal.Inc()
 
 	// Check if plate has been seen on the same road before
 	obs, ok := s.plates[cam.Road][p.Plate]
@@ -278,77 +428,100 @@ func (s *Server) handlePlate(ctx context.Context, msg []byte, cam Camera) {
 	if !ok {
 		// If not, register the plate
 		s.plates[cam.Road][p.Plate] = []*observation{&latest}
-		s.metrics.Plates.Unique++
-		return
+		s.metrics.platesUnique.Inc()
+		return nil
 	}
 	// If seen before
 	// iterate over the records and calculate the average speed
-	if v := checkViolation(latest, obs, float64(cam.Limit)); v != nil {
+	v := checkViolation(latest, obs, float64(cam.Limit))
+	if v != nil {
 		v.Road = cam.Road
-		log.Print("____________________")
-		log.Printf("violation: %+v", v)
-		log.Print("____________________")
-		s.metrics.Tickets.Queued++
-		s.ticketQueue <- v
+		utils.LoggerFromContext(ctx).Debug("violation", "ticket", fmt.Sprintf("%+v", *v))
 	}
 	// Add observation
 	s.plates[cam.Road][p.Plate] = append(s.plates[cam.Road][p.Plate], &latest)
+	return v
 }
 
 func (s *Server) ticketListen(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("context closed: %v\n", ctx.Err())
+			s.logger.Debug("ticket dispatch stopped", "err", ctx.Err())
 			return
 		case ticket := <-s.ticketQueue:
-			time.Sleep(time.Millisecond)
-
-			ticket.IncAttempts()
-
-			s.metrics.Tickets.Attempts++
-
-			// Look up dispatcher for road
-			td, err := s.nextDispatcher(ticket.Road)
-			if err != nil {
-				// log.Printf("%v.\n", err)
-				if ticket.Retries() < 50 {
-					// log.Print("%Requeuing ticket..\n")
-					// Put the ticket back in the queue
-					s.metrics.Tickets.Requeued++
-					s.ticketQueue <- ticket
-				} else {
-					s.metrics.Tickets.Dropped++
-					log.Printf("Retried to find dispatcher %d times. Dropping ticket...\n", ticket.Retries())
-				}
-				continue
-			}
+			s.dispatch(ticket)
+		}
+	}
+}
 
-			// Double check ticket not already issued for same day
-			if issued := s.ih.lookupForDate(ticket.Plate, ticket.Timestamp1, ticket.Timestamp2); issued != nil {
-				log.Printf("Ticket already issued: %+v", ticket)
-				s.metrics.Tickets.Dropped++
-				// Don't requeue and move on to next
-				continue
+// dispatch sends a ticket to a dispatcher for its road, requeuing it while there is none, then
+// holding it once it ran out of attempts or the queue is full.
+func (s *Server) dispatch(ticket *Ticket) {
+	for {
+		time.Sleep(s.settings.DispatchDelay)
+
+		ticket.IncAttempts()
+
+		s.metrics.attempts.Inc()
+
+		// Look up dispatcher for road
+		td, err := s.nextDispatcher(ticket.Road)
+		if err != nil {
+			s.logger.Debug("no dispatcher", "err", err)
+			if ticket.Retries() < s.settings.DispatchAttempts && s.requeue(ticket) {
+				s.logger.Debug("requeued ticket", "plate", ticket.Plate, "road", ticket.Road)
+				return
+			}
+			if s.holdTicket(ticket) {
+				return
 			}
+			// A dispatcher for the road connected in the meantime
+			s.metrics.requeued.Inc()
+			continue
+		}
 
-			// Send ticket
-			if err := td.send(ticket); err != nil {
-				s.metrics.Tickets.Failed++
-				log.Printf("Ticket dispatcher could not send ticket: %v\n", err)
-				// Try again later
-				s.ticketQueue <- ticket
-				continue
+		// Double check ticket not already issued for same day
+		if issued := s.ih.lookupForDate(ticket.Plate, ticket.Timestamp1, ticket.Timestamp2); issued != nil {
+			s.logger.Debug("ticket already issued", "ticket", fmt.Sprintf("%+v", *ticket))
+			s.metrics.dropped.Inc()
+			// Don't requeue and move on to next
+			return
+		}
+
+		// Send ticket
+		if err := td.send(ticket); err != nil {
+			s.metrics.failed.Inc()
+			s.logger.Warn("ticket dispatcher could not send ticket", "err", err)
+			// Try again later, or right away with the queue full
+			if s.requeue(ticket) {
+				return
 			}
-			s.metrics.Tickets.Issued++
-			s.ih.add(ticket)
-			log.Printf("Ticket issued: %+v\n", ticket)
-			log.Printf("%d left in queue.\n", len(s.ticketQueue))
+			continue
 		}
+		s.metrics.issued.Inc()
+		s.ih.add(ticket)
+		s.logger.Info("ticket issued", "ticket", fmt.Sprintf("%+v", *ticket))
+		s.logger.Debug("ticket queue", "left", len(s.ticketQueue))
+		return
+	}
+}
+
+// requeue puts a ticket back in the queue unless it is full: the dispatch loop is the queue's only
+// reader, so it can't wait for room.
+func (s *Server) requeue(ticket *Ticket) bool {
+	select {
+	case s.ticketQueue <- ticket:
+		s.metrics.requeued.Inc()
+		return true
+	default:
+		return false
 	}
 }
 
 func (s *Server) nextDispatcher(roadID uint16) (*TicketDispatcher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	dispatchers, ok := s.dispatchers[roadID]
 	if !ok {
 		return nil, fmt.Errorf("no dispatchers available for road %d", roadID)
@@ -359,28 +532,70 @@ func (s *Server) nextDispatcher(roadID uint16) (*TicketDispatcher, error) {
 	return nil, fmt.Errorf("no dispatchers available for road %d", roadID)
 }
 
-func (s *Server) startHeartbeat(ctx context.Context, msg []byte, conn net.Conn, ticker *time.Ticker) error {
+// startHeartbeat sends heartbeats at the requested interval until ctx is done, returning the
+// ticker driving them, or nil for an interval of 0.
+// startHeartbeat sends heartbeats to conn at the interval requested in msg until ctx is done.
+func (s *Server) startHeartbeat(ctx context.Context, msg []byte, conn net.Conn) {
 	// in deciseconds
 	interval := binary.BigEndian.Uint32(msg[1:])
 	if interval < 1 {
-		return nil
+		return
 	}
-	ticker = time.NewTicker(time.Millisecond * time.Duration(interval) * 100)
+	ticker := time.NewTicker(time.Millisecond * time.Duration(interval) * 100)
 
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
-				ticker.Stop()
+				return
 			case <-ticker.C:
 				hb := []byte{byte(TypeHeartbeat)}
 				if _, err := conn.Write(hb); err != nil {
-					ticker.Stop()
+					return
 				}
 			}
 		}
 	}()
-	return nil
+}
+
+// State reports the dispatchers connected for each road, the plates observed on each road, how
+// many tickets are waiting to be dispatched and how many are held for roads without a dispatcher.
+func (s *Server) State() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := speedState{
+		DispatchersPerRoad: make(map[uint16]int),
+		PlatesPerRoad:      make(map[uint16]int),
+		TicketQueueDepth:   len(s.ticketQueue),
+	}
+	for road, dispatchers := range s.dispatchers {
+		if len(dispatchers) > 0 {
+			state.DispatchersPerRoad[road] = len(dispatchers)
+		}
+	}
+	for road, plates := range s.plates {
+		state.PlatesPerRoad[road] = len(plates)
+	}
+	for _, held := range s.waiting {
+		state.TicketsHeld += len(held)
+	}
+	return state
+}
+
+// Addr returns the address the daemon listens on.
+func (s *Server) Addr() net.Addr {
+	return s.server.Addr()
+}
+
+// Conns lists the open camera and dispatcher connections.
+func (s *Server) Conns() []utils.ConnStatus {
+	return s.server.Conns()
+}
+
+// Kick closes a camera or dispatcher connection.
+func (s *Server) Kick(id uint64) bool {
+	return s.server.Kick(id)
 }
 
 func (e *ClientError) Error() string {
@@ -0,0 +1,250 @@
+package problem06
+
+import (
+	"context"
+	"errors"
+	"net"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"protohackers/utils/testharness"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestExampleTicket(t *testing.T) {
+	server := testharness.Start(t, "speed", New)
+	cam1 := testharness.Dial(t, "tcp", server.Addr)
+	cam1.Write([]byte{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c})
+	cam1.Write([]byte{0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x00})
+	cam2 := testharness.Dial(t, "tcp", server.Addr)
+	cam2.Write([]byte{0x80, 0x00, 0x7b, 0x00, 0x09, 0x00, 0x3c})
+	cam2.Write([]byte{0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x2d})
+
+	dispatcher := testharness.Dial(t, "tcp", server.Addr)
+	dispatcher.Write([]byte{0x81, 0x01, 0x00, 0x7b})
+	dispatcher.ExpectFrame(decoder, `Ticket plate="UN1X" road=123 mile1=8 timestamp1=0 mile2=9 timestamp2=45 speed=8000`)
+}
+
+func TestHeartbeat(t *testing.T) {
+	server := testharness.Start(t, "speed", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	// Every decisecond
+	conn.Write([]byte{0x40, 0x00, 0x00, 0x00, 0x01})
+	for i := 0; i < 3; i++ {
+		conn.ExpectFrame(decoder, "Heartbeat")
+	}
+}
+
+func TestHeartbeatEndsWithConnection(t *testing.T) {
+	server := testharness.Start(t, "speed", New)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		conn := testharness.Dial(t, "tcp", server.Addr)
+		// The longest interval a client can ask for, about 13 years
+		conn.Write([]byte{0x40, 0xff, 0xff, 0xff, 0xff})
+		conn.Close()
+	}
+	for deadline := time.Now().Add(2 * time.Second); runtime.NumGoroutine() > before+10; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after 50 heartbeat clients closed, from %d", runtime.NumGoroutine(), before)
+		}
+	}
+}
+
+func TestNoMetricsMessage(t *testing.T) {
+	// 'm' used to ask for the daemon's metrics as JSON, which -metrics-addr serves instead
+	server := testharness.Start(t, "speed", New)
+	conn := testharness.Dial(t, "tcp", server.Addr)
+	conn.Write([]byte{'m'})
+	conn.ExpectFrame(decoder, `Error msg="invalid message type: 6d"`)
+	conn.ExpectClosed()
+}
+
+func TestUnknownMessageIsAnError(t *testing.T) {
+	server := testharness.Start(t, "speed", New)
+	for _, msg := range [][]byte{
+		// Nothing follows while the client waits for an answer
+		{0xee},
+		// After a valid message, with a few more bytes
+		{0x40, 0x00, 0x00, 0x00, 0x00, 0xee, 0x01, 0x02},
+	} {
+		conn := testharness.Dial(t, "tcp", server.Addr)
+		conn.Write(msg)
+		if _, desc := conn.ReadFrame(decoder); desc != `Error msg="invalid message type: ee"` {
+			t.Errorf("% x: expected an error message, got %s", msg, desc)
+		}
+		conn.ExpectClosed()
+	}
+}
+
+// FuzzMaliciousClient sends arbitrary bytes to a running speed daemon and checks it is still
+// serving afterwards: a client sending garbage may lose its own connection, nothing more.
+func FuzzMaliciousClient(f *testing.F) {
+	cfg := utils.DefaultConfig()
+	cfg.Name = "speed"
+	cfg.Host, cfg.Port = "127.0.0.1", 0
+	srv, err := New(cfg)
+	if err != nil {
+		f.Fatal(err)
+	}
+	s := srv.(*Server)
+	if err := s.Start(); err != nil {
+		f.Fatal(err)
+	}
+	defer s.Stop(context.Background())
+	addr := s.server.Addr().String()
+
+	// Camera sending a plate whose length byte points past the message
+	f.Add([]byte{0x80, 0x00, 0x42, 0x00, 0x08, 0x00, 0x3c, 0x20, 0xff, 'U', 'N', '1', 'X'})
+	// Dispatcher claiming more roads than it sends
+	f.Add([]byte{0x81, 0xff, 0x00, 0x42})
+	// Dispatcher for no road at all
+	f.Add([]byte{0x81, 0x00})
+	// Two plates making a ticket
+	f.Add([]byte{
+		0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c,
+		0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x00,
+		0x80, 0x00, 0x7b, 0x00, 0x09, 0x00, 0x3c,
+		0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x2d,
+	})
+	// Heartbeat requested twice
+	f.Add([]byte{0x40, 0x00, 0x00, 0x00, 0x01, 0x40, 0x00, 0x00, 0x00, 0x01})
+	// Unknown message type
+	f.Add([]byte{0xee})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("server stopped accepting: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+		conn.Write(data)
+		conn.(*net.TCPConn).CloseWrite()
+		// Wait for the server to finish with the input, whatever it makes of it
+		buf := make([]byte, 512)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				break
+			}
+		}
+
+		// A well-behaved client is still served
+		probe, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("server stopped accepting: %v", err)
+		}
+		defer probe.Close()
+		probe.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := probe.Write([]byte{byte(TypeWantHeartbeat), 0, 0, 0, 1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := probe.Read(buf[:1]); err != nil || buf[0] != byte(TypeHeartbeat) {
+			t.Fatalf("expected a heartbeat from the server, got %x, %v", buf[:1], err)
+		}
+	})
+}
+
+func TestDrainWaitsForHeldTickets(t *testing.T) {
+	cfg := testharness.Config("speed")
+	cfg.Settings = utils.Settings{"dispatch_attempts": "1"}
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := srv.(*Server)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(context.Background())
+	addr := s.Addr().String()
+
+	// A dispatcher for another road than the ticket's
+	dispatcher := testharness.Dial(t, "tcp", addr)
+	dispatcher.Write([]byte{0x81, 0x01, 0x00, 0x01})
+	cam1 := testharness.Dial(t, "tcp", addr)
+	cam1.Write([]byte{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c})
+	cam1.Write([]byte{0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x00})
+	cam2 := testharness.Dial(t, "tcp", addr)
+	cam2.Write([]byte{0x80, 0x00, 0x7b, 0x00, 0x09, 0x00, 0x3c})
+	cam2.Write([]byte{0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x2d})
+	for deadline := time.Now().Add(2 * time.Second); s.heldTickets() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("ticket not held")
+		}
+	}
+	cam1.Close()
+	cam2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- s.Drain(ctx) }()
+	// Only dispatchers are left, but the held ticket keeps the process draining
+	dispatcher.ExpectSilence(500 * time.Millisecond)
+	if err := <-drained; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected draining to last until the deadline, got %v", err)
+	}
+}
+
+func TestInvalidSettings(t *testing.T) {
+	cfg := testharness.Config("speed")
+	for _, st := range []utils.Settings{
+		{"ticket_queue_size": "0"},
+		{"dispatch_attempts": "-1"},
+		{"dispatch_delay": "-1ms"},
+		{"dispatch_delay": "soon"},
+		{"queue": "10"},
+	} {
+		cfg.Settings = st
+		if _, err := New(cfg); err == nil {
+			t.Errorf("expected an error for %v", st)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	server := testharness.Start(t, "speed", New)
+	for _, c := range conformance.Cases("speed") {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := c.Run(server.Addr); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTinyTicketQueue(t *testing.T) {
+	cfg := testharness.Config("speed")
+	cfg.Settings = utils.Settings{"ticket_queue_size": "1", "dispatch_attempts": "1000"}
+	server := testharness.StartConfig(t, cfg, New)
+	// More tickets than the queue holds, with no dispatcher to take them
+	plates := []string{"AA01", "BB02", "CC03", "DD04", "EE05"}
+	cam1 := testharness.Dial(t, "tcp", server.Addr)
+	cam1.Write([]byte{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c})
+	cam2 := testharness.Dial(t, "tcp", server.Addr)
+	cam2.Write([]byte{0x80, 0x00, 0x7b, 0x00, 0x09, 0x00, 0x3c})
+	for _, plate := range plates {
+		cam1.Write(append([]byte{0x20, 0x04}, append([]byte(plate), 0x00, 0x00, 0x00, 0x00)...))
+		cam2.Write(append([]byte{0x20, 0x04}, append([]byte(plate), 0x00, 0x00, 0x00, 0x2d)...))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	dispatcher := testharness.Dial(t, "tcp", server.Addr)
+	dispatcher.Write([]byte{0x81, 0x01, 0x00, 0x7b})
+	seen := make(map[string]bool)
+	for range plates {
+		msg, desc := dispatcher.ReadFrame(decoder)
+		var ticket Ticket
+		if err := ticket.UnmarshalBinary(msg); err != nil {
+			t.Fatalf("expected a ticket, got %s", desc)
+		}
+		seen[ticket.Plate] = true
+	}
+	if len(seen) != len(plates) {
+		t.Errorf("expected a ticket for each of %v, got %v", plates, seen)
+	}
+}
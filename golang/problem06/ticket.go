@@ -22,7 +22,8 @@ func checkViolation(o observation, past []*observation, limit float64) *Ticket {
 		dur := prev.timestamp.Sub(o.timestamp).Abs()
 		speed := miles / dur.Hours()
 
-		if speed > limit+0.5 {
+		// Tickets are for exceeding the limit by 0.5 mph or more
+		if speed >= limit+0.5 {
 			first, second := orderObservations(o, *prev)
 			return &Ticket{
 				Plate:      o.plate,
@@ -0,0 +1,28 @@
+package problem06
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckViolationThreshold(t *testing.T) {
+	start := time.Unix(0, 0)
+	for _, tc := range []struct {
+		name   string
+		miles  uint16
+		hours  time.Duration
+		ticket bool
+	}{
+		{"at the limit", 120, 2, false},
+		{"less than 0.5 mph over", 1204, 20, false},
+		{"exactly 0.5 mph over", 121, 2, true},
+		{"more than 0.5 mph over", 122, 2, true},
+	} {
+		prev := &observation{plate: "UN1X", mile: 0, timestamp: start}
+		o := observation{plate: "UN1X", mile: tc.miles, timestamp: start.Add(tc.hours * time.Hour)}
+		got := checkViolation(o, []*observation{prev}, 60)
+		if (got != nil) != tc.ticket {
+			t.Errorf("%s: expected a ticket %v, got %+v", tc.name, tc.ticket, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package problem06
+
+import (
+	"fmt"
+	"protohackers/utils"
+	"strings"
+)
+
+func init() {
+	utils.RegisterDecoder("speed", utils.DecoderFunc(decodeFrame))
+}
+
+// decodeFrame names each message and its fields, e.g. "IAmCamera road=123 mile=8 limit=60".
+func decodeFrame(buf []byte, _ bool) (int, string) {
+	r := &frameReader{buf: buf}
+	msgType := MsgType(r.u8())
+	var desc string
+	switch msgType {
+	case TypeError:
+		desc = fmt.Sprintf("msg=%q", r.str())
+	case TypePlate:
+		desc = fmt.Sprintf("plate=%q timestamp=%d", r.str(), r.u32())
+	case TypeTicket:
+		desc = fmt.Sprintf("plate=%q road=%d mile1=%d timestamp1=%d mile2=%d timestamp2=%d speed=%d",
+			r.str(), r.u16(), r.u16(), r.u32(), r.u16(), r.u32(), r.u16())
+	case TypeWantHeartbeat:
+		desc = fmt.Sprintf("interval=%d", r.u32())
+	case TypeHeartbeat:
+	case TypeIAmCamera:
+		desc = fmt.Sprintf("road=%d mile=%d limit=%d", r.u16(), r.u16(), r.u16())
+	case TypeIAmDispatcher:
+		roads := make([]string, r.u8())
+		for i := range roads {
+			roads[i] = fmt.Sprint(r.u16())
+		}
+		desc = fmt.Sprintf("roads=[%s]", strings.Join(roads, " "))
+	default:
+		// The server hangs up on unknown messages, so there is no telling where the next one starts
+		return len(buf), fmt.Sprintf("unknown message type 0x%02x", byte(msgType))
+	}
+	if r.short {
+		return 0, ""
+	}
+	if desc == "" {
+		return r.pos, msgType.String()
+	}
+	return r.pos, msgType.String() + " " + desc
+}
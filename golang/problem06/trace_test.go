@@ -0,0 +1,45 @@
+package problem06
+
+import "testing"
+
+func TestDecodeFrame(t *testing.T) {
+	ticket := (&Ticket{Plate: "UN1X", Road: 66, Mile1: 100, Timestamp1: 123456, Mile2: 110, Timestamp2: 123816, Speed: 10000}).MarshalBinary()
+	for _, tc := range []struct {
+		frame []byte
+		n     int
+		desc  string
+	}{
+		{[]byte{0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x03, 0xe8, 0x80}, 10, `Plate plate="UN1X" timestamp=1000`},
+		{[]byte{0x20, 0x04, 'U', 'N'}, 0, ""},
+		{ticket, len(ticket), `Ticket plate="UN1X" road=66 mile1=100 timestamp1=123456 mile2=110 timestamp2=123816 speed=10000`},
+		{[]byte{0x40, 0x00, 0x00, 0x00, 0x0a}, 5, "WantHeartbeat interval=10"},
+		{[]byte{0x41}, 1, "Heartbeat"},
+		{[]byte{0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c}, 7, "IAmCamera road=66 mile=100 limit=60"},
+		{[]byte{0x81, 0x03, 0x00, 0x42, 0x01, 0x70, 0x13, 0x88}, 8, "IAmDispatcher roads=[66 368 5000]"},
+		{[]byte{0x81, 0x03, 0x00, 0x42}, 0, ""},
+		{[]byte{0x10, 0x03, 'b', 'a', 'd'}, 5, `Error msg="bad"`},
+		{[]byte{0xee, 0x01, 0x02}, 3, "unknown message type 0xee"},
+	} {
+		n, desc := decodeFrame(tc.frame, true)
+		if n != tc.n || desc != tc.desc {
+			t.Errorf("decodeFrame(% x) = %d, %q; expected %d, %q", tc.frame, n, desc, tc.n, tc.desc)
+		}
+	}
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+	addSpecMessages(f)
+	f.Add([]byte{0xee, 0x01, 0x02})
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		n, desc := decodeFrame(buf, true)
+		if n < 0 || n > len(buf) || (n > 0 && desc == "") {
+			t.Fatalf("decodeFrame(% x) = %d, %q", buf, n, desc)
+		}
+		// Complete messages take the bytes the server reads for them
+		if n > 0 {
+			if want := MsgType(buf[0]).Len(buf); want > 0 && n != want {
+				t.Fatalf("decodeFrame(% x) took %d bytes, expected %d", buf, n, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"protohackers/utils"
+	"time"
+)
+
+// runReplay implements "replay [-problem N] recording...": every session recorded with
+// -record-dir is played against a fresh instance of its problem, and the answers are compared
+// with the recorded ones. It returns the exit code.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [flags] recording...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	problem := flags.String("problem", "", "problem number or name to replay against, instead of the recorded one")
+	idle := flags.Duration("idle", 500*time.Millisecond, "how long to wait for the server to answer before moving on")
+	logLevel := flags.String("log-level", "warn", "minimum level to log: debug, info, warn or error")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if err := utils.SetupLogging(*logLevel, "text"); err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	failed := 0
+	for _, name := range flags.Args() {
+		if err := replayFile(name, *problem, *idle); err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d sessions differ\n", failed, flags.NArg())
+		return 1
+	}
+	return 0
+}
+
+func replayFile(name, problem string, idle time.Duration) error {
+	rec, err := utils.ReadRecordingFile(name)
+	if err != nil {
+		return err
+	}
+	if problem == "" {
+		problem = rec.Meta.Problem
+	}
+	r, err := utils.LookupService(problem)
+	if err != nil {
+		return err
+	}
+
+	// Serve on a private Unix socket so replays need no free port
+	dir, err := os.MkdirTemp("", "protohackers-replay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	cfg := utils.DefaultConfig()
+	cfg.Host = "unix:" + filepath.Join(dir, "{name}.sock")
+	ctx := context.Background()
+	if err := r.Service.Start(ctx, cfg); err != nil {
+		return err
+	}
+	defer r.Service.Stop(ctx)
+
+	conn, err := net.Dial("unix", filepath.Join(dir, r.Service.Name()+".sock"))
+	if err != nil {
+		return err
+	}
+	got := utils.Replay(conn, rec, idle)
+	if diff := utils.DiffOutput(rec.Outbound(), got); diff != "" {
+		return fmt.Errorf("%s", diff)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"strconv"
+)
+
+// runSelftest implements -selftest: the conformance suite of every listed problem is run against
+// a server already running at addr or, if that is empty, where this program would serve the
+// problem with the same -listen and -port. It returns the exit code.
+func runSelftest(list string, cfg utils.Config, addr string) int {
+	problems, err := parseProblems(list)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if addr == "" && utils.IsPathAddress(cfg.Host) {
+		fmt.Println("-selftest needs a host:port to test, or -selftest-addr")
+		return 2
+	}
+	failed := 0
+	for _, r := range problems {
+		target := addr
+		if target == "" {
+			port := cfg.Port
+			if len(problems) > 1 {
+				port += r.Number
+			}
+			target = net.JoinHostPort(dialHost(cfg.Host), strconv.Itoa(port))
+		}
+		n, err := conformance.Run(r.Service.Name(), target, os.Stdout)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		failed += n
+	}
+	if failed > 0 {
+		fmt.Printf("%d cases failed\n", failed)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+// Package admin serves an HTTP API to inspect and manage the running problem servers.
+//
+//	GET  /services                      running services
+//	GET  /connections[?problem=name]    live connections with their age and traffic
+//	POST /kick?problem=name&id=N        close a connection
+//	GET  /state[?problem=name]          per problem in-memory state
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"protohackers/utils"
+	"strconv"
+)
+
+type (
+	service struct {
+		Number      int    `json:"number"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	connection struct {
+		Problem string `json:"problem"`
+		utils.ConnStatus
+	}
+)
+
+// NewHandler returns the admin API for the given running services.
+func NewHandler(services []utils.Registered) http.Handler {
+	a := &api{services: services}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", a.listServices)
+	mux.HandleFunc("/connections", a.listConnections)
+	mux.HandleFunc("/kick", a.kick)
+	mux.HandleFunc("/state", a.state)
+	return mux
+}
+
+type api struct {
+	services []utils.Registered
+}
+
+// selected returns the services matching the optional problem query parameter.
+func (a *api) selected(r *http.Request) ([]utils.Registered, bool) {
+	problem := r.URL.Query().Get("problem")
+	if problem == "" {
+		return a.services, true
+	}
+	for _, reg := range a.services {
+		if reg.Service.Name() == problem || strconv.Itoa(reg.Number) == problem {
+			return []utils.Registered{reg}, true
+		}
+	}
+	return nil, false
+}
+
+func (a *api) listServices(w http.ResponseWriter, r *http.Request) {
+	list := make([]service, 0, len(a.services))
+	for _, reg := range a.services {
+		list = append(list, service{reg.Number, reg.Service.Name(), reg.Service.Description()})
+	}
+	writeJSON(w, list)
+}
+
+func (a *api) listConnections(w http.ResponseWriter, r *http.Request) {
+	services, ok := a.selected(r)
+	if !ok {
+		http.Error(w, "unknown problem", http.StatusNotFound)
+		return
+	}
+	conns := make([]connection, 0)
+	for _, reg := range services {
+		if m, ok := reg.Service.(utils.ConnManager); ok {
+			for _, c := range m.Conns() {
+				conns = append(conns, connection{reg.Service.Name(), c})
+			}
+		}
+	}
+	writeJSON(w, conns)
+}
+
+func (a *api) kick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	services, ok := a.selected(r)
+	if !ok || len(services) != 1 {
+		http.Error(w, "specify a running problem", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid connection id", http.StatusBadRequest)
+		return
+	}
+	m, ok := services[0].Service.(utils.ConnManager)
+	if !ok || !m.Kick(id) {
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) state(w http.ResponseWriter, r *http.Request) {
+	services, ok := a.selected(r)
+	if !ok {
+		http.Error(w, "unknown problem", http.StatusNotFound)
+		return
+	}
+	state := make(map[string]any)
+	for _, reg := range services {
+		if d, ok := reg.Service.(utils.StateDumper); ok {
+			state[reg.Service.Name()] = d.State()
+		}
+	}
+	writeJSON(w, state)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
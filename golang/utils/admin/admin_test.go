@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"protohackers/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer is an echo TCP server with a state to report.
+type echoServer struct {
+	*utils.TCPServer
+}
+
+func (echoServer) State() any {
+	return map[string]int{"answer": 42}
+}
+
+// startAPI runs an echo service as problem 0 behind the admin API, returning the API's URL and
+// the echo server's address.
+func startAPI(t *testing.T) (string, string) {
+	t.Helper()
+	var addr string
+	svc := utils.NewServerService("echo", "Echo", func(cfg utils.Config) (utils.Server, error) {
+		server, err := utils.NewTCPServer("127.0.0.1:0", utils.Handler(func(conn net.Conn) {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}))
+		if err != nil {
+			return nil, err
+		}
+		addr = server.Addr().String()
+		return echoServer{server}, nil
+	})
+	if err := svc.Start(context.Background(), utils.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Stop(context.Background()) })
+	api := httptest.NewServer(NewHandler([]utils.Registered{{Number: 0, Service: svc}}))
+	t.Cleanup(api.Close)
+	return api.URL, addr
+}
+
+// request makes a request to the API, failing t unless it answers with the given status, and
+// returns the body.
+func request(t *testing.T, method, url string, status int) string {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, url, status, resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestServices(t *testing.T) {
+	url, _ := startAPI(t)
+	var services []service
+	if err := json.Unmarshal([]byte(request(t, http.MethodGet, url+"/services", http.StatusOK)), &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0] != (service{0, "echo", "Echo"}) {
+		t.Errorf("unexpected services %+v", services)
+	}
+}
+
+func TestConnectionsAndKick(t *testing.T) {
+	url, addr := startAPI(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	// A round trip, so the connection is tracked
+	buf := make([]byte, 2)
+	conn.Write([]byte("hi"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var conns []connection
+	for _, query := range []string{"", "?problem=echo", "?problem=0"} {
+		if err := json.Unmarshal([]byte(request(t, http.MethodGet, url+"/connections"+query, http.StatusOK)), &conns); err != nil {
+			t.Fatal(err)
+		}
+		if len(conns) != 1 || conns[0].Problem != "echo" || conns[0].RemoteAddr != conn.LocalAddr().String() {
+			t.Fatalf("%s: unexpected connections %+v", query, conns)
+		}
+	}
+	request(t, http.MethodGet, url+"/connections?problem=speed", http.StatusNotFound)
+
+	id := conns[0].ID
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"?problem=echo", http.StatusBadRequest},
+		{"?problem=speed&id=1", http.StatusBadRequest},
+		{"?problem=echo&id=first", http.StatusBadRequest},
+		{fmt.Sprintf("?problem=echo&id=%d", id+1), http.StatusNotFound},
+	} {
+		request(t, http.MethodPost, url+"/kick"+tc.query, tc.status)
+	}
+	// None of these closed the connection
+	conn.Write([]byte("hi"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("connection closed by a refused kick: %v", err)
+	}
+
+	request(t, http.MethodPost, fmt.Sprintf("%s/kick?problem=echo&id=%d", url, id), http.StatusNoContent)
+	if _, err := conn.Read(buf); err == nil {
+		t.Errorf("expected the kicked connection to be closed")
+	}
+}
+
+func TestKickAllowsOnlyPost(t *testing.T) {
+	url, _ := startAPI(t)
+	resp, err := http.Get(url + "/kick?problem=echo&id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("expected 405 allowing POST, got %d allowing %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
+
+func TestState(t *testing.T) {
+	url, _ := startAPI(t)
+	for _, query := range []string{"", "?problem=echo"} {
+		body := request(t, http.MethodGet, url+"/state"+query, http.StatusOK)
+		if got := strings.Join(strings.Fields(body), ""); got != `{"echo":{"answer":42}}` {
+			t.Errorf("%s: unexpected state %s", query, body)
+		}
+	}
+	request(t, http.MethodGet, url+"/state?problem=speed", http.StatusNotFound)
+}
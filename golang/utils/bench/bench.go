@@ -0,0 +1,211 @@
+// Package bench is a load generator simulating many clients of a problem at once. Problems
+// register a Scenario describing how one of their clients behaves; Run starts the requested
+// number of them against a server and reports throughput, latency percentiles and errors.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scenario simulates the clients of one problem. A new Scenario is made for every run, so it can
+// hold state shared by its clients.
+type Scenario interface {
+	// Client runs simulated client number id against addr until ctx is done, timing the exchanges
+	// with rec. Returning an error counts it and starts the client over.
+	Client(ctx context.Context, addr string, id int, rec *Recorder) error
+}
+
+// ScenarioFunc adapts a function to the Scenario interface.
+type ScenarioFunc func(ctx context.Context, addr string, id int, rec *Recorder) error
+
+func (f ScenarioFunc) Client(ctx context.Context, addr string, id int, rec *Recorder) error {
+	return f(ctx, addr, id, rec)
+}
+
+var (
+	scenariosMu sync.Mutex
+	scenarios   = make(map[string]func() Scenario)
+)
+
+// Register makes the scenario returned by newScenario available for the named problem.
+func Register(problem string, newScenario func() Scenario) {
+	scenariosMu.Lock()
+	defer scenariosMu.Unlock()
+	scenarios[problem] = newScenario
+}
+
+// Available reports whether the named problem has a scenario.
+func Available(problem string) bool {
+	_, ok := lookup(problem)
+	return ok
+}
+
+func lookup(problem string) (func() Scenario, bool) {
+	scenariosMu.Lock()
+	defer scenariosMu.Unlock()
+	s, ok := scenarios[problem]
+	return s, ok
+}
+
+// Recorder collects the latency of every exchange and the errors of a run.
+type Recorder struct {
+	ctx       context.Context
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+// Observe records an exchange that took d.
+func (r *Recorder) Observe(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, d)
+}
+
+// Error counts a failed exchange. Errors caused by the end of the run are ignored.
+func (r *Recorder) Error(err error) {
+	if r.ctx.Err() != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[err.Error()]++
+}
+
+// Time runs an exchange and records its latency, or its error.
+func (r *Recorder) Time(exchange func() error) error {
+	start := time.Now()
+	if err := exchange(); err != nil {
+		return err
+	}
+	r.Observe(time.Since(start))
+	return nil
+}
+
+// Dial connects to addr and closes the connection once ctx is done, so blocked clients return.
+func Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &ctxConn{conn, stop}, nil
+}
+
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// Options tell Run what to simulate.
+type Options struct {
+	Addr     string        // Server to load
+	Clients  int           // Simulated clients running at once
+	Duration time.Duration // How long to run
+}
+
+// Result summarises a run.
+type Result struct {
+	Problem  string
+	Clients  int
+	Duration time.Duration
+	Ops      int
+	P50, P99 time.Duration
+	Max      time.Duration
+	Errors   map[string]int
+}
+
+// Throughput returns the exchanges completed per second.
+func (r Result) Throughput() float64 {
+	return float64(r.Ops) / r.Duration.Seconds()
+}
+
+// ErrorCount returns the number of errors of every kind.
+func (r Result) ErrorCount() int {
+	n := 0
+	for _, count := range r.Errors {
+		n += count
+	}
+	return n
+}
+
+// Write prints the result in a human readable form.
+func (r Result) Write(w io.Writer) {
+	fmt.Fprintf(w, "%s: %d clients for %v\n", r.Problem, r.Clients, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "  exchanges  %d (%.1f/s)\n", r.Ops, r.Throughput())
+	fmt.Fprintf(w, "  latency    p50 %v  p99 %v  max %v\n", r.P50, r.P99, r.Max)
+	fmt.Fprintf(w, "  errors     %d\n", r.ErrorCount())
+	msgs := make([]string, 0, len(r.Errors))
+	for msg := range r.Errors {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return r.Errors[msgs[i]] > r.Errors[msgs[j]] })
+	for i, msg := range msgs {
+		if i == 5 {
+			fmt.Fprintf(w, "    ... %d more kinds\n", len(msgs)-i)
+			break
+		}
+		fmt.Fprintf(w, "    %6d  %s\n", r.Errors[msg], msg)
+	}
+}
+
+// Run simulates opts.Clients clients of the named problem against opts.Addr for opts.Duration.
+func Run(ctx context.Context, problem string, opts Options) (Result, error) {
+	newScenario, ok := lookup(problem)
+	if !ok {
+		return Result{}, fmt.Errorf("no benchmark scenario for %s", problem)
+	}
+	scenario := newScenario()
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	rec := &Recorder{ctx: ctx, errors: make(map[string]int)}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for id := 0; id < opts.Clients; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := scenario.Client(ctx, opts.Addr, id, rec); err != nil {
+					rec.Error(err)
+					// Don't hammer a server that refuses connections
+					select {
+					case <-time.After(100 * time.Millisecond):
+					case <-ctx.Done():
+					}
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	res := Result{
+		Problem:  problem,
+		Clients:  opts.Clients,
+		Duration: time.Since(start),
+		Ops:      len(rec.latencies),
+		Errors:   rec.errors,
+	}
+	if n := len(rec.latencies); n > 0 {
+		sort.Slice(rec.latencies, func(i, j int) bool { return rec.latencies[i] < rec.latencies[j] })
+		res.P50 = rec.latencies[n*50/100]
+		res.P99 = rec.latencies[n*99/100]
+		res.Max = rec.latencies[n-1]
+	}
+	return res, nil
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings shared by every problem server.
+type Config struct {
+	Name string // Service name, used to tag logs
+
+	Host string // Interface to bind, e.g. "0.0.0.0", or a portless address such as "unix:/run/{name}.sock"
+	Port int    // TCP or UDP port to listen on, unused for portless addresses
+
+	MaxConns          int           // Connections served at once, 0 for unlimited
+	MaxConnsPerIP     int           // Connections per remote IP, 0 for unlimited
+	LimitQueueTimeout time.Duration // How long a connection over a limit waits for a slot, 0 rejects at once
+	LimitBanner       string        // Sent to rejected connections before closing them
+
+	IdleTimeout  time.Duration // Close connections without reads or writes for this long, 0 to disable
+	ReadTimeout  time.Duration // Max time a single read may block, 0 to disable
+	WriteTimeout time.Duration // Max time a single write may block, 0 to disable
+
+	UDPWorkers int // Datagrams handled concurrently, 0 for one per CPU
+
+	TLS *tls.Config // Serve TCP problems over TLS, nil for plaintext; see LoadTLSConfig
+
+	ProxyProtocol ProxyMode // Expect PROXY protocol headers from a load balancer
+
+	RecordDir string // Record every TCP session to a file in this directory, "" to disable
+	Trace     bool   // Log every frame, decoded by the problem's registered Decoder
+
+	Settings Settings // The problem's own tunables, decoded over its defaults; see ConfigFile
+}
+
+// DefaultConfig returns the configuration matching LISTENADDRESS.
+func DefaultConfig() Config {
+	return Config{
+		Host: "0.0.0.0",
+		Port: 4242,
+	}
+}
+
+// Address returns the host:port pair the server should listen on, or Host with {name} filled in
+// when it is a Unix socket, file descriptor or socket activation address; see Listen.
+func (c Config) Address() string {
+	if IsPathAddress(c.Host) {
+		return strings.ReplaceAll(c.Host, "{name}", c.Name)
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// UDPOptions translates the configuration into options for NewUDPServer.
+func (c Config) UDPOptions() []UDPOption {
+	return []UDPOption{
+		WithPacketName(c.Name),
+		WithWorkers(c.UDPWorkers),
+		WithPacketLogger(Logger(c.Name)),
+		WithPacketTrace(c.Trace),
+	}
+}
+
+// TCPOptions translates the configuration into options for NewTCPServer.
+func (c Config) TCPOptions() []TCPOption {
+	return []TCPOption{
+		WithName(c.Name),
+		WithLogger(Logger(c.Name)),
+		WithMaxConns(c.MaxConns),
+		WithMaxConnsPerIP(c.MaxConnsPerIP),
+		WithQueueTimeout(c.LimitQueueTimeout),
+		WithRejectBanner(c.LimitBanner),
+		WithIdleTimeout(c.IdleTimeout),
+		WithReadTimeout(c.ReadTimeout),
+		WithWriteTimeout(c.WriteTimeout),
+		WithTLSConfig(c.TLS),
+		WithProxyProtocol(c.ProxyProtocol),
+		WithRecordDir(c.RecordDir),
+		WithTrace(c.Trace),
+	}
+}
@@ -0,0 +1,200 @@
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"protohackers/utils"
+	"time"
+)
+
+// Timeout is how long a client waits for the server before giving up.
+const Timeout = 2 * time.Second
+
+// Conn is a client connection talking to a server the way the problems' clients do, returning
+// an error whenever the server doesn't answer as expected.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to addr.
+func Dial(network, addr string) (*Conn, error) {
+	conn, err := net.DialTimeout(network, addr, Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", addr, err)
+	}
+	return &Conn{conn: conn, r: bufio.NewReaderSize(conn, 64<<10)}, nil
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Write sends b, or a datagram holding b over UDP.
+func (c *Conn) Write(b []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(Timeout))
+	if _, err := c.conn.Write(b); err != nil {
+		return fmt.Errorf("cannot send %q: %w", b, err)
+	}
+	return nil
+}
+
+// WriteLine sends line followed by a newline.
+func (c *Conn) WriteLine(line string) error {
+	return c.Write([]byte(line + "\n"))
+}
+
+// ReadLine returns the next line without its newline.
+func (c *Conn) ReadLine() (string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(Timeout))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("expected a line, got %q: %w", line, err)
+	}
+	return line[:len(line)-1], nil
+}
+
+// ExpectLine returns an error unless the next line is want.
+func (c *Conn) ExpectLine(want string) error {
+	got, err := c.ReadLine()
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("expected line %q, got %q", want, got)
+	}
+	return nil
+}
+
+// ReadN returns the next n bytes.
+func (c *Conn) ReadN(n int) ([]byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(Timeout))
+	buf := make([]byte, n)
+	if got, err := io.ReadFull(c.r, buf); err != nil {
+		return nil, fmt.Errorf("expected %d bytes, got %q: %w", n, buf[:got], err)
+	}
+	return buf, nil
+}
+
+// Expect returns an error unless the next bytes are want.
+func (c *Conn) Expect(want []byte) error {
+	got, err := c.ReadN(len(want))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("expected % x, got % x", want, got)
+	}
+	return nil
+}
+
+// ReadFrame returns the next frame the server sent, split and described by d the way -trace
+// does, e.g. a problem's registered decoder.
+func (c *Conn) ReadFrame(d utils.Decoder) ([]byte, string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(Timeout))
+	want := 1
+	for {
+		buf, err := c.r.Peek(want)
+		if err != nil {
+			return nil, "", fmt.Errorf("expected a frame, got % x: %w", buf, err)
+		}
+		buf, _ = c.r.Peek(c.r.Buffered())
+		if n, desc := d.Decode(buf, false); n > 0 {
+			frame := append([]byte(nil), buf[:n]...)
+			c.r.Discard(n)
+			return frame, desc, nil
+		}
+		want = len(buf) + 1
+	}
+}
+
+// ExpectFrame returns an error unless d describes the next frame as want.
+func (c *Conn) ExpectFrame(d utils.Decoder, want string) error {
+	frame, got, err := c.ReadFrame(d)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("expected %s, got %s (% x)", want, got, frame)
+	}
+	return nil
+}
+
+// ReadDatagram returns the next datagram received over UDP.
+func (c *Conn) ReadDatagram() ([]byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(Timeout))
+	buf := make([]byte, 64<<10)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("expected a datagram: %w", err)
+	}
+	return buf[:n], nil
+}
+
+// ExpectSilence returns an error if the server sends anything within d.
+func (c *Conn) ExpectSilence(d time.Duration) error {
+	c.conn.SetReadDeadline(time.Now().Add(d))
+	var buf []byte
+	var err error
+	if c.conn.LocalAddr().Network() == "udp" {
+		buf = make([]byte, 64<<10)
+		var n int
+		n, err = c.conn.Read(buf)
+		buf = buf[:n]
+	} else {
+		buf, err = c.r.Peek(1)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("expected nothing for %v, got %q: %v", d, buf, err)
+	}
+	return nil
+}
+
+// ReadAll returns everything the server sends until it closes the connection.
+func (c *Conn) ReadAll() ([]byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(Timeout))
+	data, err := io.ReadAll(c.r)
+	if err != nil && !isReset(err) {
+		return nil, fmt.Errorf("expected the connection to be closed, got %q: %w", data, err)
+	}
+	return data, nil
+}
+
+// ExpectClosed returns an error unless the server closes the connection, without sending
+// anything more first.
+func (c *Conn) ExpectClosed() error {
+	c.conn.SetReadDeadline(time.Now().Add(Timeout))
+	buf, err := c.r.Peek(1)
+	if err == nil {
+		buf, _ = c.r.Peek(c.r.Buffered())
+		return fmt.Errorf("expected the connection to be closed, got %q", buf)
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !isReset(err) {
+		return fmt.Errorf("expected the connection to be closed: %w", err)
+	}
+	return nil
+}
+
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+// CloseWrite shuts down the sending side of a TCP connection, telling the server it got all.
+func (c *Conn) CloseWrite() error {
+	if err := c.conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return fmt.Errorf("cannot close the sending side: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,94 @@
+// Package conformance holds each problem's spec as executable scenarios: the example sessions
+// and edge cases of the problem statements, run against a real server. The problems' tests run
+// them against a fresh server, and -selftest against a running one at any address, so every
+// scenario keeps to names, keys and roads of its own. Scenarios return an error rather than
+// fail a test, so the binary running them doesn't link the testing package.
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Case is a scenario from a problem's spec, returning an error unless the server at addr
+// follows it.
+type Case struct {
+	Name string
+	Run  func(addr string) error
+}
+
+var (
+	suitesMu     sync.Mutex
+	suites       = make(map[string][]Case)
+	healthChecks = make(map[string]func(addr string) error)
+)
+
+// Register adds cases to the named problem's suite.
+func Register(problem string, cases ...Case) {
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	suites[problem] = append(suites[problem], cases...)
+}
+
+// Cases returns the named problem's suite.
+func Cases(problem string) []Case {
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	return suites[problem]
+}
+
+// RegisterHealthCheck sets the named problem's health check, the shortest exchange showing its
+// server answers, and adds it to the problem's suite.
+func RegisterHealthCheck(problem string, check func(addr string) error) {
+	Register(problem, Case{Name: "health check", Run: check})
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	healthChecks[problem] = check
+}
+
+// Check runs the named problem's health check against the server at addr.
+func Check(problem, addr string) error {
+	suitesMu.Lock()
+	check, ok := healthChecks[problem]
+	suitesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no health check for %s", problem)
+	}
+	return check(addr)
+}
+
+// Unique returns prefix followed by random letters, so scenarios run against a shared server
+// don't meet each other's users and keys.
+func Unique(prefix string) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := []byte(prefix)
+	for i := 0; i < 6; i++ {
+		b = append(b, letters[rand.Intn(len(letters))])
+	}
+	return string(b)
+}
+
+// Run runs the named problem's suite against the server at addr, reporting every case to w.
+// It returns how many cases failed.
+func Run(problem, addr string, w io.Writer) (int, error) {
+	cases := Cases(problem)
+	if len(cases) == 0 {
+		return 0, fmt.Errorf("no conformance suite for %s", problem)
+	}
+	failed := 0
+	for _, c := range cases {
+		start := time.Now()
+		err := c.Run(addr)
+		took := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			fmt.Fprintf(w, "FAIL %s/%s (%v): %v\n", problem, c.Name, took, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "ok   %s/%s (%v)\n", problem, c.Name, took)
+	}
+	return failed, nil
+}
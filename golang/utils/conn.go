@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deadlineConn refreshes the deadlines of the wrapped connection on every Read and Write. The
+// deadlines the handler sets apply as well, whenever they are earlier.
+type deadlineConn struct {
+	net.Conn
+	idle          time.Duration // max time without reads or writes
+	readTimeout   time.Duration // max time a single Read may block
+	writeTimeout  time.Duration // max time a single Write may block
+	lastActive    atomic.Int64  // UnixNano of the last successful read or write
+	readDeadline  atomic.Int64  // UnixNano of the handler's read deadline, 0 for none
+	writeDeadline atomic.Int64  // UnixNano of the handler's write deadline, 0 for none
+	serverRead    atomic.Int64  // UnixNano of the read deadline of the Read in progress, 0 for none
+	readMu        sync.Mutex    // orders the read deadlines set by Read and SetReadDeadline
+}
+
+func newDeadlineConn(conn net.Conn, idle, read, write time.Duration) *deadlineConn {
+	c := &deadlineConn{
+		Conn:         conn,
+		idle:         idle,
+		readTimeout:  read,
+		writeTimeout: write,
+	}
+	c.touch()
+	return c
+}
+
+func (c *deadlineConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *deadlineConn) idleSince() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// earlier returns whichever deadline comes first, the zero time standing for none.
+func earlier(a, b time.Time) time.Time {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func storeDeadline(v *atomic.Int64, t time.Time) {
+	if t.IsZero() {
+		v.Store(0)
+	} else {
+		v.Store(t.UnixNano())
+	}
+}
+
+func loadDeadline(v *atomic.Int64) time.Time {
+	if ns := v.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// SetReadDeadline sets the handler's read deadline, applying at once if it is earlier than the
+// one of a Read in progress.
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	storeDeadline(&c.readDeadline, t)
+	return c.Conn.SetReadDeadline(earlier(t, loadDeadline(&c.serverRead)))
+}
+
+// SetWriteDeadline sets the handler's write deadline.
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	storeDeadline(&c.writeDeadline, t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	defer c.serverRead.Store(0)
+	for {
+		start := time.Now()
+		var deadline time.Time
+		if c.readTimeout > 0 {
+			deadline = start.Add(c.readTimeout)
+		}
+		if c.idle > 0 {
+			deadline = earlier(deadline, c.idleSince().Add(c.idle))
+		}
+		c.readMu.Lock()
+		storeDeadline(&c.serverRead, deadline)
+		err := c.Conn.SetReadDeadline(earlier(deadline, loadDeadline(&c.readDeadline)))
+		c.readMu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.touch()
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			return n, err
+		}
+		// A write from another goroutine counts as activity: keep waiting as long as the
+		// connection hasn't really been idle and the per-read timeout hasn't run out
+		readExpired := c.readTimeout > 0 && time.Since(start) >= c.readTimeout
+		idleExpired := c.idle > 0 && time.Since(c.idleSince()) >= c.idle
+		handlerDeadline := loadDeadline(&c.readDeadline)
+		handlerExpired := !handlerDeadline.IsZero() && !time.Now().Before(handlerDeadline)
+		if n > 0 || readExpired || idleExpired || handlerExpired {
+			return n, err
+		}
+	}
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		deadline := earlier(time.Now().Add(c.writeTimeout), loadDeadline(&c.writeDeadline))
+		if err := c.Conn.SetWriteDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// HandlerFunc serves a single connection. The context carries the connection's ConnInfo and is
+// cancelled when the server shuts down.
+type HandlerFunc func(ctx context.Context, conn net.Conn)
+
+// Handler adapts a handler that has no use for the connection context.
+func Handler(h func(net.Conn)) HandlerFunc {
+	return func(_ context.Context, conn net.Conn) {
+		h(conn)
+	}
+}
+
+// ConnInfo describes an accepted connection.
+type ConnInfo struct {
+	ID         uint64    // Unique per server, counting from 1; 0 for UDP datagrams
+	Problem    string    // Name of the service serving the connection
+	RemoteAddr net.Addr  // Peer address
+	AcceptedAt time.Time // When the connection was accepted
+
+	TLS *tls.ConnectionState // Negotiated TLS state, nil for plaintext connections
+}
+
+type connInfoKey struct{}
+
+func withConnInfo(ctx context.Context, info ConnInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// ConnInfoFromContext returns the ConnInfo stored by the server in a handler's context.
+func ConnInfoFromContext(ctx context.Context) (ConnInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(ConnInfo)
+	return info, ok
+}
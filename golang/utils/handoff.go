@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A restart hands every open listening socket to a new copy of the binary, so it can take over
+// new connections while the old process finishes serving the ones it has. The child finds the
+// sockets listed in PROTOHACKERS_INHERIT as address=fd pairs, and tells the parent it is serving
+// by writing to the pipe in PROTOHACKERS_READY_FD.
+const (
+	inheritEnv  = "PROTOHACKERS_INHERIT"
+	readyFDEnv  = "PROTOHACKERS_READY_FD"
+	inheritSep  = ";"
+	streamKind  = "stream"
+	packetKind  = "packet"
+	readyStatus = "ready\n"
+)
+
+// filer is implemented by the listeners and packet conns that can hand their socket over.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// sockets tracks the listening sockets opened with Listen and ListenPacket, keyed by the
+// listener or packet conn wrapping each, and the ones inherited from a parent process that
+// haven't been claimed yet, keyed by kind and address.
+var sockets struct {
+	mu        sync.Mutex
+	open      map[io.Closer]openSocket
+	once      sync.Once
+	inherited map[string]*os.File
+}
+
+// openSocket is a tracked socket and the key a restarted process claims it by. Several sockets
+// can share a key, e.g. two listeners on :0, so it can't identify them.
+type openSocket struct {
+	key string
+	s   filer
+}
+
+func socketKey(kind, address string) string {
+	return kind + " " + address
+}
+
+// inheritedSocket returns the socket the parent process handed over for address, if any.
+func inheritedSocket(kind, address string) *os.File {
+	sockets.once.Do(loadInheritedSockets)
+	sockets.mu.Lock()
+	defer sockets.mu.Unlock()
+	key := socketKey(kind, address)
+	f := sockets.inherited[key]
+	delete(sockets.inherited, key)
+	return f
+}
+
+func loadInheritedSockets() {
+	sockets.inherited = make(map[string]*os.File)
+	list := os.Getenv(inheritEnv)
+	os.Unsetenv(inheritEnv)
+	if list == "" {
+		return
+	}
+	for _, entry := range strings.Split(list, inheritSep) {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			continue
+		}
+		fd, err := strconv.Atoi(entry[i+1:])
+		if err != nil {
+			continue
+		}
+		sockets.inherited[entry[:i]] = os.NewFile(uintptr(fd), entry[:i])
+	}
+}
+
+// trackListener records an open listener so a restart can hand it over, until it is closed.
+func trackListener(address string, l net.Listener) net.Listener {
+	s, ok := l.(filer)
+	if !ok {
+		return l
+	}
+	tl := &trackedListener{l}
+	trackSocket(tl, streamKind, address, s)
+	return tl
+}
+
+// trackPacketConn records an open packet conn so a restart can hand it over, until it is closed.
+func trackPacketConn(address string, c net.PacketConn) net.PacketConn {
+	s, ok := c.(filer)
+	if !ok {
+		return c
+	}
+	tc := &trackedPacketConn{c}
+	trackSocket(tc, packetKind, address, s)
+	return tc
+}
+
+func trackSocket(owner io.Closer, kind, address string, s filer) {
+	sockets.mu.Lock()
+	defer sockets.mu.Unlock()
+	if sockets.open == nil {
+		sockets.open = make(map[io.Closer]openSocket)
+	}
+	sockets.open[owner] = openSocket{socketKey(kind, address), s}
+}
+
+func untrackSocket(owner io.Closer) {
+	sockets.mu.Lock()
+	defer sockets.mu.Unlock()
+	delete(sockets.open, owner)
+}
+
+type trackedListener struct {
+	net.Listener
+}
+
+func (l *trackedListener) Close() error {
+	untrackSocket(l)
+	return l.Listener.Close()
+}
+
+type trackedPacketConn struct {
+	net.PacketConn
+}
+
+func (c *trackedPacketConn) Close() error {
+	untrackSocket(c)
+	return c.PacketConn.Close()
+}
+
+// Restart starts a new copy of the running binary with the same arguments and hands it every
+// socket opened with Listen or ListenPacket. It returns once the child reports it is serving,
+// after which the caller should stop accepting and drain its connections; see Drainer.
+// If the child fails to start within timeout, the sockets stay with the caller.
+func Restart(timeout time.Duration) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+
+	sockets.mu.Lock()
+	var files []*os.File
+	var inherit []string
+	handed := make(map[string]bool)
+	for _, open := range sockets.open {
+		// The child claims sockets by address, so it can only take one of those sharing one
+		if handed[open.key] {
+			continue
+		}
+		handed[open.key] = true
+		f, err := open.s.File()
+		if err != nil {
+			sockets.mu.Unlock()
+			closeFiles(files)
+			return nil, fmt.Errorf("hand over %s: %w", open.key, err)
+		}
+		// The child gets ExtraFiles starting at descriptor 3
+		inherit = append(inherit, open.key+"="+strconv.Itoa(3+len(files)))
+		files = append(files, f)
+	}
+	sockets.mu.Unlock()
+	defer closeFiles(files)
+
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, notify)
+	cmd.Env = append(os.Environ(),
+		inheritEnv+"="+strings.Join(inherit, inheritSep),
+		readyFDEnv+"="+strconv.Itoa(3+len(files)),
+	)
+	err = cmd.Start()
+	notify.Close()
+	if err != nil {
+		return nil, fmt.Errorf("start %s: %w", exe, err)
+	}
+	go cmd.Wait()
+
+	status := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(readyStatus))
+		if _, err := io.ReadFull(ready, buf); err != nil || string(buf) != readyStatus {
+			status <- errors.New("new process exited before it was ready")
+			return
+		}
+		status <- nil
+	}()
+	select {
+	case err = <-status:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process not ready after %v", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+
+	// Closing the old listeners must not remove the socket files the child is now serving on
+	sockets.mu.Lock()
+	for _, open := range sockets.open {
+		if l, ok := open.s.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+	}
+	sockets.mu.Unlock()
+	return cmd.Process, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// NotifyReady tells the process that started this one with Restart that it is serving, so it can
+// stop accepting connections. It does nothing if the process wasn't started by a restart.
+func NotifyReady() {
+	fd, err := strconv.Atoi(os.Getenv(readyFDEnv))
+	os.Unsetenv(readyFDEnv)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	f.Write([]byte(readyStatus))
+}
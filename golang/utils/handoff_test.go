@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"io"
+	"testing"
+)
+
+func isTracked(owner io.Closer) bool {
+	sockets.mu.Lock()
+	defer sockets.mu.Unlock()
+	_, ok := sockets.open[owner]
+	return ok
+}
+
+func TestTrackedSocketsSharingAnAddress(t *testing.T) {
+	// Both listen on different ports, but under the same address
+	first, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if !isTracked(first) || !isTracked(second) {
+		t.Fatalf("expected both listeners tracked, got %v and %v", isTracked(first), isTracked(second))
+	}
+
+	first.Close()
+	if isTracked(first) {
+		t.Error("closed listener still tracked")
+	}
+	if !isTracked(second) {
+		t.Error("closing a listener untracked another one on the same address")
+	}
+	second.Close()
+	if isTracked(second) {
+		t.Error("closed listener still tracked")
+	}
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"protohackers/utils/metrics"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	connsAccepted   = metrics.NewCounterVec("protohackers_connections_accepted_total", "TCP connections accepted.", "problem")
+	connsActive     = metrics.NewGaugeVec("protohackers_connections_active", "TCP connections currently open.", "problem")
+	connsRejected   = metrics.NewCounterVec("protohackers_connections_rejected_total", "TCP connections turned away by a connection limit.", "problem", "reason")
+	datagramsRecv   = metrics.NewCounterVec("protohackers_datagrams_received_total", "UDP datagrams received.", "problem")
+	datagramsBig    = metrics.NewCounterVec("protohackers_datagrams_oversized_total", "UDP datagrams dropped for exceeding the maximum datagram size.", "problem")
+	bytesReceived   = metrics.NewCounterVec("protohackers_bytes_received_total", "Payload bytes received from clients.", "problem")
+	bytesSent       = metrics.NewCounterVec("protohackers_bytes_sent_total", "Payload bytes sent to clients.", "problem")
+	handlerDuration = metrics.NewHistogramVec("protohackers_handler_duration_seconds", "Time spent serving a TCP connection or a UDP datagram.", metrics.DefaultBuckets, "problem")
+	messages        = metrics.NewCounterVec("protohackers_messages_total", "Protocol messages handled, by message type.", "problem", "type")
+	handlerPanics   = metrics.NewCounterVec("protohackers_handler_panics_total", "Handler panics recovered, each closing only its own connection or datagram.", "problem")
+)
+
+// CountMessage records that the handler serving ctx handled a protocol message of the given type.
+func CountMessage(ctx context.Context, msgType string) {
+	info, _ := ConnInfoFromContext(ctx)
+	messages.With(info.Problem, msgType).Inc()
+}
+
+// recoverHandler recovers a panicking handler, logging it with its stack and counting it, so it
+// only takes down its own connection. It must be deferred directly.
+func recoverHandler(ctx context.Context, problem string, cleanup func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	handlerPanics.With(problem).Inc()
+	LoggerFromContext(ctx).Error("handler panicked", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+	if cleanup != nil {
+		cleanup()
+	}
+}
+
+// ConnStatus is a snapshot of a live connection.
+type ConnStatus struct {
+	ID         uint64    `json:"id"`
+	RemoteAddr string    `json:"remote_addr"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+	BytesIn    uint64    `json:"bytes_in"`
+	BytesOut   uint64    `json:"bytes_out"`
+}
+
+// liveConn keeps the per-connection traffic totals of a tracked connection.
+type liveConn struct {
+	info     ConnInfo
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+func (l *liveConn) status() ConnStatus {
+	return ConnStatus{
+		ID:         l.info.ID,
+		RemoteAddr: l.info.RemoteAddr.String(),
+		AcceptedAt: l.info.AcceptedAt,
+		AgeSeconds: time.Since(l.info.AcceptedAt).Seconds(),
+		BytesIn:    l.bytesIn.Load(),
+		BytesOut:   l.bytesOut.Load(),
+	}
+}
+
+// countingConn adds the bytes read and written through the connection to its own totals and to
+// the traffic counters.
+type countingConn struct {
+	net.Conn
+	live     *liveConn
+	received *metrics.Counter
+	sent     *metrics.Counter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.live.bytesIn.Add(uint64(n))
+	c.received.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.live.bytesOut.Add(uint64(n))
+	c.sent.Add(float64(n))
+	return n, err
+}
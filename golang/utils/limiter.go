@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitStats counts connections turned away by the connection limits.
+type LimitStats struct {
+	Global uint64 // rejected because the server was full
+	PerIP  uint64 // rejected because the remote IP had too many connections
+}
+
+// limiter caps the number of connections served at once, globally and per remote IP.
+// A zero limit means unlimited.
+type limiter struct {
+	maxConns      int
+	maxPerIP      int
+	queueTimeout  time.Duration // how long to wait for a slot; zero rejects immediately
+	mu            sync.Mutex
+	total         int
+	perIP         map[string]int
+	changed       chan struct{} // closed and replaced whenever a slot frees up
+	rejectedTotal atomic.Uint64
+	rejectedPerIP atomic.Uint64
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		perIP:   make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+func (l *limiter) enabled() bool {
+	return l.maxConns > 0 || l.maxPerIP > 0
+}
+
+var (
+	errServerFull = errors.New("too many connections")
+	errIPFull     = errors.New("too many connections from this IP")
+)
+
+// acquire waits for a free slot for addr. It returns a release function, or the limit that
+// made the connection get rejected.
+func (l *limiter) acquire(ctx context.Context, addr net.Addr) (func(), error) {
+	ip := remoteIP(addr)
+	err := l.wait(ctx, func() error {
+		if l.maxConns > 0 && l.total >= l.maxConns {
+			return errServerFull
+		}
+		if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+			return errIPFull
+		}
+		l.total++
+		l.perIP[ip]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		l.free(func() {
+			l.total--
+			l.releaseIP(ip)
+		})
+	}, nil
+}
+
+// reserve waits for a global slot for a connection whose remote IP is not known yet, like one
+// behind a proxy that still has to send its PROXY header. Once it is known, claim counts the
+// connection against its IP's limit too.
+func (l *limiter) reserve(ctx context.Context) (func(), error) {
+	err := l.wait(ctx, func() error {
+		if l.maxConns > 0 && l.total >= l.maxConns {
+			return errServerFull
+		}
+		l.total++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { l.free(func() { l.total-- }) }, nil
+}
+
+// claim waits for a slot for addr's IP on behalf of a connection holding a reserved global slot.
+func (l *limiter) claim(ctx context.Context, addr net.Addr) (func(), error) {
+	ip := remoteIP(addr)
+	err := l.wait(ctx, func() error {
+		if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+			return errIPFull
+		}
+		l.perIP[ip]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { l.free(func() { l.releaseIP(ip) }) }, nil
+}
+
+// wait calls take with the lock held until it takes a slot, or returns the limit that made the
+// connection get rejected once the queue timeout expires.
+func (l *limiter) wait(ctx context.Context, take func() error) error {
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		l.mu.Lock()
+		err := take()
+		wait := l.changed
+		l.mu.Unlock()
+		if err == nil {
+			return nil
+		}
+
+		rejected := false
+		if timeout == nil {
+			rejected = true
+		} else {
+			select {
+			case <-wait:
+			case <-timeout:
+				rejected = true
+			case <-ctx.Done():
+				rejected = true
+			}
+		}
+		if rejected {
+			if err == errServerFull {
+				l.rejectedTotal.Add(1)
+			} else {
+				l.rejectedPerIP.Add(1)
+			}
+			return err
+		}
+	}
+}
+
+// free gives back a slot with release, waking up connections waiting for one.
+func (l *limiter) free(release func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	release()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *limiter) releaseIP(ip string) {
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+func (l *limiter) stats() LimitStats {
+	return LimitStats{
+		Global: l.rejectedTotal.Load(),
+		PerIP:  l.rejectedPerIP.Load(),
+	}
+}
+
+// remoteIP returns the host part of addr, or the whole address for non IP networks.
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Besides host:port pairs, servers accept these listen addresses:
+//
+//	unix:/path/to/sock   a Unix domain socket; unixgram for UDP servers
+//	fd:3                 an already open socket inherited as file descriptor 3
+//	systemd              the first socket passed by systemd socket activation (LISTEN_FDS)
+//	systemd:2            the third one
+//	systemd:name         the one named name in LISTEN_FDNAMES, i.e. FileDescriptorName=name
+//
+// A {name} placeholder in an address is replaced with the problem name, so several problems can
+// share one -listen flag.
+
+// listenScheme returns the scheme of a special listen address, or "" for a host:port pair.
+func listenScheme(address string) string {
+	for _, scheme := range []string{"unix", "fd", "systemd"} {
+		if address == scheme || strings.HasPrefix(address, scheme+":") {
+			return scheme
+		}
+	}
+	return ""
+}
+
+// IsPathAddress tells whether address is a Unix socket, file descriptor or socket activation
+// address rather than a host to combine with a port.
+func IsPathAddress(address string) bool {
+	return listenScheme(address) != ""
+}
+
+// Listen opens a stream listener on a host:port pair or one of the special addresses above. A
+// listener for address handed over by a restart is reused instead; see Restart.
+func Listen(address string) (net.Listener, error) {
+	l, err := listen(address)
+	if err != nil {
+		return nil, err
+	}
+	return trackListener(address, l), nil
+}
+
+func listen(address string) (net.Listener, error) {
+	if f := inheritedSocket(streamKind, address); f != nil {
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	scheme, rest, _ := strings.Cut(address, ":")
+	switch listenScheme(address) {
+	case "unix":
+		removeStaleSocket(rest)
+		return net.Listen("unix", rest)
+	case "fd", "systemd":
+		f, err := inheritedFile(scheme, rest)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", address)
+}
+
+// ListenPacket opens a datagram socket on a host:port pair or one of the special addresses
+// above, or reuses the one handed over by a restart.
+func ListenPacket(address string) (net.PacketConn, error) {
+	c, err := listenPacket(address)
+	if err != nil {
+		return nil, err
+	}
+	return trackPacketConn(address, c), nil
+}
+
+func listenPacket(address string) (net.PacketConn, error) {
+	if f := inheritedSocket(packetKind, address); f != nil {
+		defer f.Close()
+		return net.FilePacketConn(f)
+	}
+	scheme, rest, _ := strings.Cut(address, ":")
+	switch listenScheme(address) {
+	case "unix":
+		removeStaleSocket(rest)
+		return net.ListenPacket("unixgram", rest)
+	case "fd", "systemd":
+		f, err := inheritedFile(scheme, rest)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return net.FilePacketConn(f)
+	}
+	return net.ListenPacket("udp", address)
+}
+
+// removeStaleSocket deletes a socket file left behind by a process that didn't clean up, so
+// listening on it again doesn't fail with "address already in use". Live sockets are left alone.
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	for _, network := range []string{"unix", "unixgram"} {
+		if conn, err := net.Dial(network, path); err == nil {
+			conn.Close()
+			return
+		} else if !errors.Is(err, syscall.ECONNREFUSED) && !errors.Is(err, syscall.EPROTOTYPE) {
+			return
+		}
+	}
+	os.Remove(path)
+}
+
+// inheritedFile returns the file for an fd:N or systemd[:index|:name] address. Each inherited
+// socket can be used by one server only.
+func inheritedFile(scheme, selector string) (*os.File, error) {
+	if scheme == "fd" {
+		fd, err := strconv.Atoi(selector)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor %q", selector)
+		}
+		return os.NewFile(uintptr(fd), "fd:"+selector), nil
+	}
+
+	activated.once.Do(loadActivatedFiles)
+	activated.mu.Lock()
+	defer activated.mu.Unlock()
+	if len(activated.files) == 0 {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_FDS is not set for this process)")
+	}
+	i := -1
+	if selector == "" {
+		i = 0
+	} else if n, err := strconv.Atoi(selector); err == nil {
+		i = n
+	} else {
+		for j, name := range activated.names {
+			if name == selector && activated.files[j] != nil {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 || i >= len(activated.files) {
+		return nil, fmt.Errorf("no systemd socket %q among %d passed", selector, len(activated.files))
+	}
+	f := activated.files[i]
+	if f == nil {
+		return nil, fmt.Errorf("systemd socket %q is already in use", selector)
+	}
+	activated.files[i] = nil
+	return f, nil
+}
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+var activated struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files []*os.File // nil once handed out
+	names []string
+}
+
+// loadActivatedFiles picks up the sockets systemd passed following sd_listen_fds(3), and clears
+// the variables so child processes don't try to use them too.
+func loadActivatedFiles() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		activated.files = append(activated.files, os.NewFile(uintptr(fd), name))
+		activated.names = append(activated.names, name)
+	}
+}
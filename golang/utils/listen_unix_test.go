@@ -0,0 +1,111 @@
+//go:build unix
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func roundTrip(t *testing.T, network, address string) {
+	t.Helper()
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected ping back, got %q", buf)
+	}
+}
+
+func TestUnixSocketServers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stream.sock")
+	// A socket file left behind by a crashed server doesn't get in the way
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, err := NewTCPServer("unix:"+path, Handler(echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+	roundTrip(t, "unix", path)
+
+	gram := filepath.Join(dir, "gram.sock")
+	udp, err := NewUDPServer("unix:"+gram, func(ctx context.Context, pkt []byte, from net.Addr, reply func([]byte) error) {
+		reply(pkt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp.Start()
+	defer udp.Stop(context.Background())
+
+	// Unix datagram clients need an address of their own to be answered
+	local := &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: "unixgram"}
+	client, err := net.DialUnix("unixgram", local, &net.UnixAddr{Name: gram, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte("ping"))
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Errorf("expected ping back, got %q", buf[:n])
+	}
+}
+
+func TestInheritedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	// The server takes ownership of the descriptor, as if inherited from a parent process
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTCPServer(fmt.Sprintf("fd:%d", fd), Handler(echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+	roundTrip(t, "tcp", server.Addr().String())
+
+	if _, err := Listen("systemd:speed"); err == nil {
+		t.Error("expected an error without socket activation")
+	}
+}
@@ -0,0 +1,321 @@
+// Package metrics implements counters, gauges and histograms exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds metric families and renders them for scraping.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*vec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*vec)}
+}
+
+// Default is the registry the package level constructors register with.
+var Default = NewRegistry()
+
+// DefaultBuckets are histogram buckets suited to request latencies in seconds.
+var DefaultBuckets = []float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5, 10, 60}
+
+type sample interface {
+	write(w io.Writer, name, labels string)
+}
+
+// vec is a metric family: one sample per distinct set of label values.
+type vec struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	newSample  func() sample
+	mu         sync.Mutex
+	samples    map[string]sample
+}
+
+func (r *Registry) register(name, help, typ string, labelNames []string, newSample func() sample) *vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.families[name]; ok {
+		if v.typ != typ || strings.Join(v.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Sprintf("metric %s registered twice with different types or labels", name))
+		}
+		return v
+	}
+	v := &vec{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		newSample:  newSample,
+		samples:    make(map[string]sample),
+	}
+	r.families[name] = v
+	return v
+}
+
+func (v *vec) with(labelValues []string) sample {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s wants %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	var key strings.Builder
+	for i, value := range labelValues {
+		if i > 0 {
+			key.WriteByte(',')
+		}
+		fmt.Fprintf(&key, `%s="%s"`, v.labelNames[i], escape(value))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.samples[key.String()]
+	if !ok {
+		s = v.newSample()
+		v.samples[key.String()] = s
+	}
+	return s
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.samples))
+	for k := range v.samples {
+		keys = append(keys, k)
+	}
+	samples := make(map[string]sample, len(v.samples))
+	for k, s := range v.samples {
+		samples[k] = s
+	}
+	v.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, strings.ReplaceAll(v.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+	for _, k := range keys {
+		samples[k].write(w, v.name, k)
+	}
+}
+
+// WriteText renders every metric in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		r.mu.Lock()
+		v := r.families[name]
+		r.mu.Unlock()
+		v.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+// atomicFloat is a float64 updated with compare-and-swap.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increases the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.v.Add(delta)
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.Load()
+}
+
+func (c *Counter) write(w io.Writer, name, labels string) {
+	writeSample(w, name, labels, c.Value())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v atomicFloat
+}
+
+func (g *Gauge) Set(v float64) {
+	g.v.Set(v)
+}
+
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.Add(delta)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.Load()
+}
+
+func (g *Gauge) write(w io.Writer, name, labels string) {
+	writeSample(w, name, labels, g.Value())
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	upperBounds []float64
+	counts      []atomic.Uint64 // one per bucket, plus +Inf
+	sum         atomicFloat
+	count       atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upperBounds: buckets,
+		counts:      make([]atomic.Uint64, len(buckets)+1),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	h.counts[i].Add(1)
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.upperBounds {
+		cumulative += h.counts[i].Load()
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(bound)+`"`), float64(cumulative))
+	}
+	cumulative += h.counts[len(h.upperBounds)].Load()
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(cumulative))
+	writeSample(w, name+"_sum", labels, h.sum.Load())
+	writeSample(w, name+"_count", labels, float64(h.count.Load()))
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct{ v *vec }
+
+// GaugeVec is a family of gauges partitioned by label values.
+type GaugeVec struct{ v *vec }
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct{ v *vec }
+
+// NewCounterVec registers a counter family, or returns the one already registered under name.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labelNames, func() sample { return &Counter{} })}
+}
+
+// NewGaugeVec registers a gauge family, or returns the one already registered under name.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labelNames, func() sample { return &Gauge{} })}
+}
+
+// NewHistogramVec registers a histogram family with the given bucket upper bounds, or returns the
+// one already registered under name.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{r.register(name, help, "histogram", labelNames, func() sample { return newHistogram(sorted) })}
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.v.with(labelValues).(*Counter)
+}
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.v.with(labelValues).(*Gauge)
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.v.with(labelValues).(*Histogram)
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labelNames...)
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labelNames...)
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+	if labels == "" {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(value))
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
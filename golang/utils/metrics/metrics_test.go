@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	conns := r.NewCounterVec("conns_total", "Connections accepted.", "problem")
+	conns.With("echo").Inc()
+	conns.With("echo").Add(2)
+	conns.With(`we"ird`).Inc()
+	r.NewGaugeVec("active", "Open connections.").With().Set(4)
+	latency := r.NewHistogramVec("latency_seconds", "Handler latency.", []float64{1, 0.1}, "problem")
+	latency.With("echo").Observe(0.05)
+	latency.With("echo").Observe(0.5)
+	latency.With("echo").Observe(7)
+
+	// Registering again hands back the same family
+	r.NewCounterVec("conns_total", "Connections accepted.", "problem").With("echo").Inc()
+
+	var out strings.Builder
+	r.WriteText(&out)
+	expected := `# HELP active Open connections.
+# TYPE active gauge
+active 4
+# HELP conns_total Connections accepted.
+# TYPE conns_total counter
+conns_total{problem="echo"} 4
+conns_total{problem="we\"ird"} 1
+# HELP latency_seconds Handler latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{problem="echo",le="0.1"} 1
+latency_seconds_bucket{problem="echo",le="1"} 2
+latency_seconds_bucket{problem="echo",le="+Inf"} 3
+latency_seconds_sum{problem="echo"} 7.55
+latency_seconds_count{problem="echo"} 3
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
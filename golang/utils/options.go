@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"time"
+)
+
+// TCPOption configures optional TCPServer behaviour.
+type TCPOption func(*TCPServer)
+
+// WithAcceptErrorHandler sets the callback told about accept errors the server cannot recover
+// from. The server stops accepting new connections after reporting one.
+func WithAcceptErrorHandler(fn func(error)) TCPOption {
+	return func(s *TCPServer) {
+		s.acceptErr = fn
+	}
+}
+
+// WithName sets the problem name the server's metrics are labelled with.
+func WithName(name string) TCPOption {
+	return func(s *TCPServer) {
+		s.name = name
+	}
+}
+
+// WithLogger sets the logger the server and its handlers log to.
+func WithLogger(logger *slog.Logger) TCPOption {
+	return func(s *TCPServer) {
+		s.logger = logger
+	}
+}
+
+// WithMaxConns limits how many connections are served at the same time.
+func WithMaxConns(n int) TCPOption {
+	return func(s *TCPServer) {
+		s.limiter.maxConns = n
+	}
+}
+
+// WithMaxConnsPerIP limits how many connections a single remote IP may hold open.
+func WithMaxConnsPerIP(n int) TCPOption {
+	return func(s *TCPServer) {
+		s.limiter.maxPerIP = n
+	}
+}
+
+// WithQueueTimeout makes connections over a limit wait up to d for a free slot instead of
+// being rejected straight away.
+func WithQueueTimeout(d time.Duration) TCPOption {
+	return func(s *TCPServer) {
+		s.limiter.queueTimeout = d
+	}
+}
+
+// WithRejectBanner sets a message written to connections turned away by a limit before closing them.
+func WithRejectBanner(banner string) TCPOption {
+	return func(s *TCPServer) {
+		s.rejectBanner = banner
+	}
+}
+
+// WithTLSConfig makes the server speak TLS with cfg. Handlers still receive a net.Conn carrying
+// the decrypted stream, and find the negotiated state in ConnInfo.TLS.
+func WithTLSConfig(cfg *tls.Config) TCPOption {
+	return func(s *TCPServer) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithProxyProtocol makes the server read a PROXY protocol v1 or v2 header from new connections,
+// so RemoteAddr, the logs and the per-IP limits see the original client instead of the proxy.
+func WithProxyProtocol(mode ProxyMode) TCPOption {
+	return func(s *TCPServer) {
+		s.proxyMode = mode
+	}
+}
+
+// WithRecordDir records the bytes each connection reads and writes, with timestamps, to a file
+// per session in dir. See ReadRecording and Replay.
+func WithRecordDir(dir string) TCPOption {
+	return func(s *TCPServer) {
+		s.recordDir = dir
+	}
+}
+
+// WithTrace logs every frame read or written, decoded by the decoder registered for the
+// server's problem name. See RegisterDecoder.
+func WithTrace(enabled bool) TCPOption {
+	return func(s *TCPServer) {
+		s.trace = enabled
+	}
+}
+
+// WithIdleTimeout closes connections that neither read nor write anything for d.
+func WithIdleTimeout(d time.Duration) TCPOption {
+	return func(s *TCPServer) {
+		s.idleTimeout = d
+	}
+}
+
+// WithReadTimeout makes a single Read fail if no data arrives within d.
+func WithReadTimeout(d time.Duration) TCPOption {
+	return func(s *TCPServer) {
+		s.readTimeout = d
+	}
+}
+
+// WithWriteTimeout makes a single Write fail if it can't complete within d.
+func WithWriteTimeout(d time.Duration) TCPOption {
+	return func(s *TCPServer) {
+		s.writeTimeout = d
+	}
+}
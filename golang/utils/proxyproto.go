@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyMode tells whether a TCPServer expects PROXY protocol headers in front of its connections.
+type ProxyMode int
+
+const (
+	ProxyOff      ProxyMode = iota // Connections are served as they are
+	ProxyOptional                  // A v1 or v2 header is used if present
+	ProxyStrict                    // Connections without a valid header are closed
+)
+
+// ParseProxyMode parses "off", "optional" or "strict".
+func ParseProxyMode(s string) (ProxyMode, error) {
+	switch strings.ToLower(s) {
+	case "", "off":
+		return ProxyOff, nil
+	case "optional":
+		return ProxyOptional, nil
+	case "strict":
+		return ProxyStrict, nil
+	}
+	return ProxyOff, fmt.Errorf("unknown PROXY protocol mode %q, expected off, optional or strict", s)
+}
+
+func (m ProxyMode) String() string {
+	switch m {
+	case ProxyOptional:
+		return "optional"
+	case ProxyStrict:
+		return "strict"
+	}
+	return "off"
+}
+
+// proxyHeaderTimeout bounds how long the server waits for a PROXY header. In optional mode a
+// client that waits for the server to speak first is only served once it expires.
+var proxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errNoProxyHeader = errors.New("no PROXY protocol header")
+)
+
+// proxyV1MaxLen is the longest a v1 header may be, CRLF included.
+const proxyV1MaxLen = 107
+
+// proxyConn is a connection whose addresses come from a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader // holds what was read past the header
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	// Only drain the buffer: a timeout hit while peeking for the header stays in the reader
+	if c.r.Buffered() > 0 {
+		return c.r.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr { return c.remote }
+func (c *proxyConn) LocalAddr() net.Addr  { return c.local }
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from conn. Without a header it returns
+// errNoProxyHeader in strict mode and a connection replaying what was read otherwise.
+func readProxyHeader(conn net.Conn, mode ProxyMode) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	pc := &proxyConn{Conn: conn, r: bufio.NewReaderSize(conn, 256), remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+	sig, err := peekSignature(pc.r)
+	if err != nil {
+		if mode == ProxyStrict {
+			return nil, err
+		}
+		// The client sent less than a signature before the timeout or end-of-file, e.g. because
+		// it waits for the server to speak first: it is served with whatever it did send
+		err = nil
+	}
+	switch {
+	case bytes.Equal(sig, proxyV1Sig):
+		err = pc.readV1()
+	case bytes.Equal(sig, proxyV2Sig):
+		err = pc.readV2()
+	case mode == ProxyStrict:
+		err = errNoProxyHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// peekSignature peeks at the start of the stream for as long as it could still be one of the
+// signatures, so clients without a header aren't kept waiting for bytes they never send.
+// It returns the matched signature, or nil.
+func peekSignature(r *bufio.Reader) ([]byte, error) {
+	for n := 1; ; n++ {
+		buf, err := r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		v1 := n <= len(proxyV1Sig) && bytes.HasPrefix(proxyV1Sig, buf)
+		v2 := n <= len(proxyV2Sig) && bytes.HasPrefix(proxyV2Sig, buf)
+		switch {
+		case v1 && n == len(proxyV1Sig):
+			return proxyV1Sig, nil
+		case v2 && n == len(proxyV2Sig):
+			return proxyV2Sig, nil
+		case !v1 && !v2:
+			return nil, nil
+		}
+	}
+}
+
+// readV1 parses a header like "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for n := len(proxyV1Sig) + 1; ; n++ {
+		buf, err := c.r.Peek(n)
+		if err != nil {
+			return fmt.Errorf("PROXY v1 header: %w", err)
+		}
+		if buf[n-1] == '\n' {
+			line = buf
+			break
+		}
+		if n == proxyV1MaxLen {
+			return errors.New("PROXY v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("PROXY v1 header not terminated by CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	c.r.Discard(len(line))
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+func parseV1Addr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q in PROXY v1 header", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in PROXY v1 header", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 parses the binary header: signature, version and command, address family and
+// protocol, address block length, then the address block.
+func (c *proxyConn) readV2() error {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return fmt.Errorf("PROXY v2 header: %w", err)
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version %d", hdr[12]>>4)
+	}
+	command := hdr[12] & 0x0f
+	family := hdr[13]
+	block := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c.r, block); err != nil {
+		return fmt.Errorf("PROXY v2 addresses: %w", err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL: the proxy's own connection, e.g. a health check
+		return nil
+	case 0x1: // PROXY
+	default:
+		return fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+	var ipLen int
+	switch family {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default: // UDP, unix sockets and unspecified keep the real addresses
+		return nil
+	}
+	if len(block) < 2*ipLen+4 {
+		return errors.New("PROXY v2 address block too short")
+	}
+	c.remote = &net.TCPAddr{
+		IP:   net.IP(block[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(block[2*ipLen:])),
+	}
+	c.local = &net.TCPAddr{
+		IP:   net.IP(block[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(block[2*ipLen+2:])),
+	}
+	return nil
+}
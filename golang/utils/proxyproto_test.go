@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(command byte, src, dst *net.TCPAddr) []byte {
+	hdr := append([]byte{}, proxyV2Sig...)
+	family := byte(0x11)
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil {
+		family = 0x21
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	}
+	block := append(append([]byte{}, srcIP...), dstIP...)
+	block = binary.BigEndian.AppendUint16(block, uint16(src.Port))
+	block = binary.BigEndian.AppendUint16(block, uint16(dst.Port))
+	hdr = append(hdr, 0x20|command, family)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(block)))
+	return append(hdr, block...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	src4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	dst4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}
+
+	for _, tc := range []struct {
+		name   string
+		mode   ProxyMode
+		sent   []byte
+		remote string // "" keeps the real peer address
+		err    bool
+		rest   string // read after the header
+	}{
+		{"v1 tcp4", ProxyStrict, []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nhello"), "192.0.2.1:56324", false, "hello"},
+		{"v1 tcp6", ProxyStrict, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\nhello"), "[2001:db8::1]:1234", false, "hello"},
+		{"v1 unknown", ProxyStrict, []byte("PROXY UNKNOWN\r\nhello"), "", false, "hello"},
+		{"v1 malformed", ProxyStrict, []byte("PROXY TCP4 192.0.2.1\r\nhello"), "", true, ""},
+		{"v1 bad port", ProxyStrict, []byte("PROXY TCP4 192.0.2.1 192.0.2.2 99999 443\r\nhello"), "", true, ""},
+		{"v1 no CRLF", ProxyStrict, []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\nhello"), "", true, ""},
+		{"v2 tcp4", ProxyStrict, append(proxyV2Header(1, src4, dst4), "hello"...), "192.0.2.1:56324", false, "hello"},
+		{"v2 tcp6", ProxyStrict, append(proxyV2Header(1, src6, dst6), "hello"...), "[2001:db8::1]:1234", false, "hello"},
+		{"v2 local", ProxyStrict, append(proxyV2Header(0, src4, dst4), "hello"...), "", false, "hello"},
+		{"strict without header", ProxyStrict, []byte("hello"), "", true, ""},
+		{"optional without header", ProxyOptional, []byte("hello"), "", false, "hello"},
+		{"optional partial signature", ProxyOptional, []byte("PROhello"), "", false, "PROhello"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				client.Write(tc.sent)
+				client.Close()
+			}()
+
+			conn, err := readProxyHeader(server, tc.mode)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tc.remote
+			if want == "" {
+				want = server.RemoteAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("expected remote address %s, got %s", want, got)
+			}
+			rest, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(rest) != tc.rest {
+				t.Errorf("expected %q after the header, got %q", tc.rest, rest)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolServer(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0", func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+		info, _ := ConnInfoFromContext(ctx)
+		fmt.Fprintf(conn, "%s %s\n", conn.RemoteAddr(), info.RemoteAddr)
+		io.Copy(io.Discard, conn)
+	}, WithProxyProtocol(ProxyStrict), WithMaxConnsPerIP(1), WithRejectBanner("busy\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	// Cleanups run last first, so the clients are closed before the server drains
+	t.Cleanup(func() { server.Stop(context.Background()) })
+
+	dial := func(header string) *bufio.Reader {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(header))
+		return bufio.NewReader(conn)
+	}
+	expect := func(r *bufio.Reader, want string) {
+		t.Helper()
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("expected %q, got %q", want, line)
+		}
+	}
+
+	// Two clients behind the same proxy connection IP are limited separately
+	expect(dial("PROXY TCP4 192.0.2.1 192.0.2.2 1000 443\r\n"), "192.0.2.1:1000 192.0.2.1:1000\n")
+	expect(dial("PROXY TCP4 192.0.2.9 192.0.2.2 1000 443\r\n"), "192.0.2.9:1000 192.0.2.9:1000\n")
+	// while a second connection from the same client is over its limit
+	expect(dial("PROXY TCP4 192.0.2.1 192.0.2.2 1001 443\r\n"), "busy\n")
+	// and strict mode turns away clients that don't send a header
+	expect(dial("hello\n"), "")
+}
+
+func TestProxyHeaderHoldsASlot(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0", Handler(echo), WithProxyProtocol(ProxyStrict), WithMaxConns(1), WithRejectBanner("busy\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	t.Cleanup(func() { server.Stop(context.Background()) })
+
+	// A client still to send its header takes the only slot
+	slow, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { slow.Close() })
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		server.limiter.mu.Lock()
+		total := server.limiter.total
+		server.limiter.mu.Unlock()
+		if total == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the header-less connection holds no slot")
+		}
+	}
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Turned away before its header is read, so it sends none: unread bytes would reset the
+	// connection and lose the banner
+	conn.SetDeadline(time.Now().Add(time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "busy\n" {
+		t.Errorf("expected banner %q, got %q", "busy\n", got)
+	}
+	if stats := server.Rejected(); stats.Global != 1 || stats.PerIP != 0 {
+		t.Errorf("expected 1 global rejection, got %+v", stats)
+	}
+}
+
+func TestProxyOptionalServerSpeaksFirst(t *testing.T) {
+	prev := proxyHeaderTimeout
+	proxyHeaderTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { proxyHeaderTimeout = prev })
+
+	server, err := NewTCPServer("127.0.0.1:0", func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+		conn.Write([]byte("Welcome! What shall I call you?\n"))
+		io.Copy(conn, conn)
+	}, WithProxyProtocol(ProxyOptional))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	t.Cleanup(func() { server.Stop(context.Background()) })
+
+	for _, sent := range []string{"", "PRO"} {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// Nothing, or the start of a signature, until the server speaks
+		conn.Write([]byte(sent))
+		r := bufio.NewReader(conn)
+		line, err := r.ReadString('\n')
+		if err != nil || line != "Welcome! What shall I call you?\n" {
+			t.Fatalf("sent %q: expected the prompt, got %q: %v", sent, line, err)
+		}
+		conn.Write([]byte("bob\n"))
+		if line, err := r.ReadString('\n'); err != nil || line != sent+"bob\n" {
+			t.Errorf("sent %q: expected %q echoed, got %q: %v", sent, sent+"bob\n", line, err)
+		}
+	}
+}
+
+func FuzzReadProxyHeader(f *testing.F) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443}
+	f.Add([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nhello"))
+	f.Add([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\nhello"))
+	f.Add([]byte("PROXY UNKNOWN\r\nhello"))
+	f.Add(append(proxyV2Header(1, src, dst), "hello"...))
+	f.Add(append(proxyV2Header(0, src, dst), "hello"...))
+	f.Add([]byte("hello"))
+	f.Fuzz(func(t *testing.T, sent []byte) {
+		for _, mode := range []ProxyMode{ProxyStrict, ProxyOptional} {
+			client, server := net.Pipe()
+			go func() {
+				client.Write(sent)
+				client.Close()
+			}()
+			conn, err := readProxyHeader(server, mode)
+			if err != nil {
+				server.Close()
+				continue
+			}
+			rest, err := io.ReadAll(conn)
+			server.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The header is taken off the front of the stream, and nothing else is
+			if !bytes.HasSuffix(sent, rest) {
+				t.Fatalf("%q read as %q after the header", sent, rest)
+			}
+			hasSig := bytes.HasPrefix(sent, proxyV1Sig) || bytes.HasPrefix(sent, proxyV2Sig)
+			if (mode == ProxyStrict && !hasSig) || (!hasSig && len(rest) != len(sent)) {
+				t.Fatalf("%q in mode %v read as %q after the header", sent, mode, rest)
+			}
+		}
+	})
+}
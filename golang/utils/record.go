@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A recording holds one TCP session: a magic string, a JSON RecordingMeta prefixed with its
+// length, then one event per Read or Write made by the handler. An event is a direction byte
+// ('<' from the client, '>' to it), the microseconds since the session started and the length
+// of the data as uvarints, then the data.
+const recordingMagic = "PHREC1\n"
+
+const (
+	eventInbound  = '<'
+	eventOutbound = '>'
+)
+
+// maxEventSize bounds the data of a single event, so a corrupt length can't exhaust memory.
+const maxEventSize = 1 << 24
+
+// RecordingMeta describes a recorded session.
+type RecordingMeta struct {
+	Problem    string    `json:"problem"`
+	ConnID     uint64    `json:"conn_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Start      time.Time `json:"start"`
+}
+
+// RecordedEvent is data the client sent (Inbound) or the server answered, At after the start.
+type RecordedEvent struct {
+	At      time.Duration
+	Inbound bool
+	Data    []byte
+}
+
+// Recording is a session read back by ReadRecording.
+type Recording struct {
+	Meta   RecordingMeta
+	Events []RecordedEvent
+}
+
+// Inbound returns everything the client sent.
+func (r *Recording) Inbound() []byte {
+	return r.join(true)
+}
+
+// Outbound returns everything the server answered.
+func (r *Recording) Outbound() []byte {
+	return r.join(false)
+}
+
+func (r *Recording) join(inbound bool) []byte {
+	var buf bytes.Buffer
+	for _, e := range r.Events {
+		if e.Inbound == inbound {
+			buf.Write(e.Data)
+		}
+	}
+	return buf.Bytes()
+}
+
+// recorder appends a session's events to its recording file.
+type recorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	start time.Time
+	err   error // first write error, after which recording stops
+}
+
+// newRecorder starts the recording of a connection in a new file of dir. The file is named after
+// the problem, time, process ID and connection ID, since connection IDs start over with every
+// process, and an existing file is never overwritten.
+func newRecorder(dir string, info ConnInfo) (*recorder, error) {
+	name := fmt.Sprintf("%s-%s-%d-%d.phrec", info.Problem, info.AcceptedAt.Format("20060102-150405"), os.Getpid(), info.ID)
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{f: f, w: bufio.NewWriter(f), start: info.AcceptedAt}
+	meta, _ := json.Marshal(RecordingMeta{
+		Problem:    info.Problem,
+		ConnID:     info.ID,
+		RemoteAddr: info.RemoteAddr.String(),
+		Start:      info.AcceptedAt,
+	})
+	r.w.WriteString(recordingMagic)
+	r.w.Write(binary.AppendUvarint(nil, uint64(len(meta))))
+	r.w.Write(meta)
+	return r, nil
+}
+
+func (r *recorder) event(direction byte, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	hdr := []byte{direction}
+	hdr = binary.AppendUvarint(hdr, uint64(time.Since(r.start).Microseconds()))
+	hdr = binary.AppendUvarint(hdr, uint64(len(data)))
+	r.w.Write(hdr)
+	_, r.err = r.w.Write(data)
+}
+
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recordingConn records the bytes read and written through the connection.
+type recordingConn struct {
+	net.Conn
+	rec  *recorder
+	once sync.Once
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.rec.event(eventInbound, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.rec.event(eventOutbound, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	c.finish()
+	return c.Conn.Close()
+}
+
+// finish closes the recording file, if the handler didn't already by closing the connection.
+func (c *recordingConn) finish() {
+	c.once.Do(func() { c.rec.close() })
+}
+
+// ReadRecording parses a session recorded with WithRecordDir.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(recordingMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != recordingMagic {
+		return nil, errors.New("not a session recording")
+	}
+	metaLen, err := binary.ReadUvarint(br)
+	if err == nil && metaLen > maxEventSize {
+		err = fmt.Errorf("%d bytes is too big", metaLen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+	meta := make([]byte, metaLen)
+	if _, err := io.ReadFull(br, meta); err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+	rec := &Recording{}
+	if err := json.Unmarshal(meta, &rec.Meta); err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+
+	for {
+		direction, err := br.ReadByte()
+		if err == io.EOF {
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if direction != eventInbound && direction != eventOutbound {
+			return nil, fmt.Errorf("unknown event type %q after %d events", direction, len(rec.Events))
+		}
+		at, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(rec.Events), err)
+		}
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(rec.Events), err)
+		}
+		if n > maxEventSize {
+			return nil, fmt.Errorf("event %d: %d bytes is too big", len(rec.Events), n)
+		}
+		data := make([]byte, n)
+		// A recording cut short by a crash still replays up to its last complete event
+		if _, err := io.ReadFull(br, data); err != nil {
+			return rec, nil
+		}
+		rec.Events = append(rec.Events, RecordedEvent{
+			At:      time.Duration(at) * time.Microsecond,
+			Inbound: direction == eventInbound,
+			Data:    data,
+		})
+	}
+}
+
+// ReadRecordingFile reads the recording in the named file.
+func ReadRecordingFile(name string) (*Recording, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec, err := ReadRecording(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return rec, nil
+}
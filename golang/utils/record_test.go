@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upper answers every line with its upper case version.
+func upper(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		conn.Write([]byte(strings.ToUpper(scanner.Text()) + "\n"))
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	server, err := NewTCPServer("127.0.0.1:0", Handler(upper), WithName("upper"), WithRecordDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, line := range []string{"hello\n", "world\n"} {
+		conn.Write([]byte(line))
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+	server.Stop(context.Background())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "upper-*.phrec"))
+	if len(files) != 1 {
+		t.Fatalf("expected one recording, got %v", files)
+	}
+	rec, err := ReadRecordingFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Meta.Problem != "upper" || rec.Meta.ConnID != 1 {
+		t.Errorf("unexpected metadata %+v", rec.Meta)
+	}
+	if got := string(rec.Inbound()); got != "hello\nworld\n" {
+		t.Errorf("expected the client's lines to be recorded, got %q", got)
+	}
+	if got := string(rec.Outbound()); got != "HELLO\nWORLD\n" {
+		t.Errorf("expected the server's answers to be recorded, got %q", got)
+	}
+
+	// Replaying against the same handler gives the same answers, against another one a diff
+	for _, tc := range []struct {
+		handler func(net.Conn)
+		same    bool
+	}{
+		{upper, true},
+		{echo, false},
+	} {
+		replayServer, err := NewTCPServer("127.0.0.1:0", Handler(tc.handler))
+		if err != nil {
+			t.Fatal(err)
+		}
+		replayServer.Start()
+		conn, err := net.Dial("tcp", replayServer.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Replay(conn, rec, 200*time.Millisecond)
+		replayServer.Stop(context.Background())
+		if diff := DiffOutput(rec.Outbound(), got); (diff == "") != tc.same {
+			t.Errorf("replay gave %q, diff %q", got, diff)
+		}
+	}
+}
+
+func TestRecordingNeverOverwrites(t *testing.T) {
+	dir := t.TempDir()
+	info := ConnInfo{ID: 1, Problem: "upper", RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242}, AcceptedAt: time.Now()}
+	rec, err := newRecorder(dir, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.event(eventInbound, []byte("hello\n"))
+	if err := rec.close(); err != nil {
+		t.Fatal(err)
+	}
+	// The same connection ID at the same second, as after a quick restart reusing the PID
+	if _, err := newRecorder(dir, info); err == nil {
+		t.Fatal("expected an error for an existing recording")
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.phrec"))
+	if len(files) != 1 || !strings.Contains(files[0], fmt.Sprintf("-%d-1.phrec", os.Getpid())) {
+		t.Fatalf("expected one recording named after the process, got %v", files)
+	}
+	got, err := ReadRecordingFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Inbound()) != "hello\n" {
+		t.Errorf("expected the first recording intact, got %q", got.Inbound())
+	}
+}
+
+func TestReadRecordingErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"not a recording",
+		recordingMagic + "\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01",
+		recordingMagic + "\x02{}X",
+	} {
+		if _, err := ReadRecording(bytes.NewReader([]byte(data))); err == nil {
+			t.Errorf("expected an error for %q", data)
+		}
+	}
+}
+
+func FuzzReadRecording(f *testing.F) {
+	meta := `{"problem":"echo","remote":"127.0.0.1:1234"}`
+	valid := recordingMagic + string(rune(len(meta))) + meta + "<\x00\x05hello>\x0a\x05hello"
+	f.Add([]byte(valid))
+	f.Add([]byte(valid[:len(valid)-2]))
+	f.Add([]byte(recordingMagic + "\x02{}X"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rec, err := ReadRecording(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		n := 0
+		for _, ev := range rec.Events {
+			n += len(ev.Data)
+		}
+		if n > len(data) || len(rec.Inbound())+len(rec.Outbound()) != n {
+			t.Fatalf("%d bytes of events read from %d bytes", n, len(data))
+		}
+	})
+}
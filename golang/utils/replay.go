@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// Replay plays the client side of rec over conn and returns everything the server answered.
+// Before sending each chunk it waits until the server has answered as much as it had at that
+// point of the recording, so request/response protocols see the same interleaving; a server that
+// stays quiet for idle is not waited for any longer. Once all input is sent, the write side is
+// closed and the answers collected until the server closes the connection or goes quiet.
+func Replay(conn net.Conn, rec *Recording, idle time.Duration) []byte {
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				chunks <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var got []byte
+	closed := false
+	waitFor := func(n int) {
+		for len(got) < n && !closed {
+			select {
+			case chunk, ok := <-chunks:
+				if !ok {
+					closed = true
+					return
+				}
+				got = append(got, chunk...)
+			case <-time.After(idle):
+				return
+			}
+		}
+	}
+
+	expected := 0
+	for _, e := range rec.Events {
+		if !e.Inbound {
+			expected += len(e.Data)
+			continue
+		}
+		waitFor(expected)
+		if closed {
+			break
+		}
+		if _, err := conn.Write(e.Data); err != nil {
+			// The server hung up early, which the answers will show
+			break
+		}
+	}
+	waitFor(expected)
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	waitFor(math.MaxInt)
+
+	conn.Close()
+	for range chunks {
+	}
+	return got
+}
+
+// DiffOutput describes where got first differs from want, or returns "" if they are equal.
+func DiffOutput(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	i := 0
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+	line := bytes.Count(want[:i], []byte{'\n'}) + 1
+	from := i - 32
+	if from < 0 {
+		from = 0
+	}
+	context := func(b []byte) []byte {
+		to := i + 32
+		if to > len(b) {
+			to = len(b)
+		}
+		return b[from:to]
+	}
+	return fmt.Sprintf("output differs at byte %d (line %d), %d bytes expected and %d received\n"+
+		"  expected ...%q\n"+
+		"  received ...%q",
+		i, line, len(want), len(got), context(want), context(got))
+}
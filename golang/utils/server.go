@@ -0,0 +1,9 @@
+package utils
+
+import "context"
+
+// Server is a problem server that can be started and stopped by its owner.
+type Server interface {
+	Start() error
+	Stop(ctx context.Context) error
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Service is a protohackers problem solution that can be listed, described and run.
+type Service interface {
+	Name() string
+	Description() string
+	Start(ctx context.Context, cfg Config) error
+	Stop(ctx context.Context) error
+}
+
+// StateDumper is implemented by servers and services that can describe their in-memory state
+// for the admin API. The returned value is encoded as JSON.
+type StateDumper interface {
+	State() any
+}
+
+// ConnManager is implemented by servers and services that can list and close their connections.
+type ConnManager interface {
+	Conns() []ConnStatus
+	Kick(id uint64) bool
+}
+
+// Drainer is implemented by servers and services that can hand new connections over to another
+// process: Drain stops accepting, lets the open connections finish by themselves until ctx is
+// done, then stops like Stop.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// Registered is a Service together with the problem number it solves.
+type Registered struct {
+	Number  int
+	Service Service
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[int]Service)
+)
+
+// Register makes a service available under its problem number. It is meant to be called from init functions.
+func Register(number int, svc Service) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[number]; exists {
+		panic(fmt.Sprintf("service for problem %d registered twice", number))
+	}
+	registry[number] = svc
+}
+
+// Services returns every registered service ordered by problem number.
+func Services() []Registered {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	services := make([]Registered, 0, len(registry))
+	for number, svc := range registry {
+		services = append(services, Registered{number, svc})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Number < services[j].Number })
+	return services
+}
+
+// LookupService finds a registered service by problem number or by name.
+func LookupService(key string) (Registered, error) {
+	number, err := strconv.Atoi(key)
+	for _, r := range Services() {
+		if (err == nil && r.Number == number) || r.Service.Name() == key {
+			return r, nil
+		}
+	}
+	return Registered{}, fmt.Errorf("unknown problem %q", key)
+}
+
+// ServerService adapts a Server constructor to the Service interface.
+type ServerService struct {
+	name        string
+	description string
+	newServer   func(Config) (Server, error)
+	mu          sync.Mutex
+	server      Server
+}
+
+// NewServerService returns a Service that builds its Server with newServer on every Start.
+func NewServerService(name, description string, newServer func(Config) (Server, error)) *ServerService {
+	return &ServerService{
+		name:        name,
+		description: description,
+		newServer:   newServer,
+	}
+}
+
+func (s *ServerService) Name() string {
+	return s.name
+}
+
+func (s *ServerService) Description() string {
+	return s.description
+}
+
+func (s *ServerService) Start(ctx context.Context, cfg Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server != nil {
+		return fmt.Errorf("%s already started", s.name)
+	}
+	if cfg.Name == "" {
+		cfg.Name = s.name
+	}
+	server, err := s.newServer(cfg)
+	if err != nil {
+		return err
+	}
+	if err := server.Start(); err != nil {
+		return err
+	}
+	s.server = server
+	return nil
+}
+
+func (s *ServerService) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.server = nil
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Stop(ctx)
+}
+
+// Drain drains the running server if it supports it, and stops it otherwise.
+func (s *ServerService) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.server = nil
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	if d, ok := server.(Drainer); ok {
+		return d.Drain(ctx)
+	}
+	return server.Stop(ctx)
+}
+
+func (s *ServerService) running() Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.server
+}
+
+// State returns the running server's state, if it can describe it.
+func (s *ServerService) State() any {
+	if d, ok := s.running().(StateDumper); ok {
+		return d.State()
+	}
+	return nil
+}
+
+// Conns lists the running server's connections, if it keeps track of them.
+func (s *ServerService) Conns() []ConnStatus {
+	if m, ok := s.running().(ConnManager); ok {
+		return m.Conns()
+	}
+	return nil
+}
+
+// Kick closes one of the running server's connections.
+func (s *ServerService) Kick(id uint64) bool {
+	if m, ok := s.running().(ConnManager); ok {
+		return m.Kick(id)
+	}
+	return false
+}
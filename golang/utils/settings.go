@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings are the tunables of one problem by key: its [name] section of the config file, with
+// PROTOHACKERS_SET_<NAME>_<KEY> environment variables on top. Values are kept as text and
+// converted by Decode, so they can come from either.
+type Settings map[string]string
+
+// settingsEnvPrefix sets settings variables apart from the others, such as PROTOHACKERS_LISTEN
+// and inheritEnv, whatever the problems are named.
+const settingsEnvPrefix = "PROTOHACKERS_SET_"
+
+// ConfigFile is a parsed config file, in a subset of TOML: a [name] section per problem holding
+// key = value lines, and # comments. Keys are bare and values are single-line strings, integers,
+// floats or booleans; durations are strings such as "1ms". Arrays, tables other than the
+// sections, dotted or quoted keys, multi-line strings and dates are rejected with an error
+// saying so, rather than misread.
+type ConfigFile struct {
+	sections map[string]Settings
+}
+
+// LoadConfigFile reads the named config file.
+func LoadConfigFile(name string) (*ConfigFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cf, err := ParseConfigFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return cf, nil
+}
+
+// ParseConfigFile parses a config file; see ConfigFile for its syntax.
+func ParseConfigFile(r io.Reader) (*ConfigFile, error) {
+	cf := &ConfigFile{sections: make(map[string]Settings)}
+	var section Settings
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			name, err := parseSectionHeader(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if _, exists := cf.sections[name]; exists {
+				return nil, fmt.Errorf("line %d: section [%s] defined twice", line, name)
+			}
+			section = make(Settings)
+			cf.sections[name] = section
+			continue
+		}
+		key, value, err := parseSetting(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if section == nil {
+			return nil, fmt.Errorf("line %d: setting %s outside of a [problem] section", line, key)
+		}
+		if _, exists := section[key]; exists {
+			return nil, fmt.Errorf("line %d: %s set twice", line, key)
+		}
+		section[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// endOfLine returns an error unless rest holds nothing but a comment.
+func endOfLine(rest string) error {
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return fmt.Errorf("unexpected %q", rest)
+	}
+	return nil
+}
+
+func parseSectionHeader(text string) (string, error) {
+	if strings.HasPrefix(text, "[[") {
+		return "", fmt.Errorf("arrays of tables are not supported")
+	}
+	end := strings.IndexByte(text, ']')
+	if end < 0 {
+		return "", fmt.Errorf("unterminated section header %q", text)
+	}
+	name := strings.TrimSpace(text[1:end])
+	if strings.ContainsAny(name, `."'`) {
+		return "", fmt.Errorf("nested and quoted table names are not supported, got [%s]", name)
+	}
+	if !isBareKey(name) {
+		return "", fmt.Errorf("invalid section name %q", name)
+	}
+	return name, endOfLine(text[end+1:])
+}
+
+func parseSetting(text string) (key, value string, err error) {
+	if text[0] == '"' || text[0] == '\'' {
+		return "", "", fmt.Errorf("quoted keys are not supported, got %s", text)
+	}
+	key, rest, ok := strings.Cut(text, "=")
+	key = strings.TrimSpace(key)
+	if !ok {
+		return "", "", fmt.Errorf("expected key = value, got %q", text)
+	}
+	if strings.Contains(key, ".") {
+		return "", "", fmt.Errorf("dotted keys are not supported, got %s", key)
+	}
+	if !isBareKey(key) {
+		return "", "", fmt.Errorf("invalid key %q", key)
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return "", "", fmt.Errorf("no value for %s", key)
+	}
+	if strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, "'''") {
+		return "", "", fmt.Errorf("multi-line strings are not supported, for %s", key)
+	}
+	switch rest[0] {
+	case '"':
+		// Go's escapes are a superset of TOML's
+		end := 1
+		for ; end < len(rest) && rest[end] != '"'; end++ {
+			if rest[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(rest) {
+			return "", "", fmt.Errorf("unterminated string for %s", key)
+		}
+		if value, err = strconv.Unquote(rest[:end+1]); err != nil {
+			return "", "", fmt.Errorf("invalid string for %s: %s", key, rest[:end+1])
+		}
+		return key, value, endOfLine(rest[end+1:])
+	case '\'':
+		end := strings.IndexByte(rest[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string for %s", key)
+		}
+		return key, rest[1 : end+1], endOfLine(rest[end+2:])
+	case '[', '{':
+		return "", "", fmt.Errorf("arrays and inline tables are not supported, for %s", key)
+	}
+	value, _, _ = strings.Cut(rest, "#")
+	value = strings.TrimSpace(value)
+	_, intErr := strconv.ParseInt(value, 0, 64)
+	_, floatErr := strconv.ParseFloat(value, 64)
+	if _, dateErr := time.Parse(time.DateOnly, value[:min(len(value), len(time.DateOnly))]); dateErr == nil {
+		return "", "", fmt.Errorf("dates are not supported, for %s", key)
+	}
+	if value != "true" && value != "false" && intErr != nil && floatErr != nil {
+		return "", "", fmt.Errorf("invalid value %q for %s, strings must be quoted", value, key)
+	}
+	return key, value, nil
+}
+
+// Sections returns the names of the file's sections, sorted.
+func (cf *ConfigFile) Sections() []string {
+	if cf == nil {
+		return nil
+	}
+	names := make([]string, 0, len(cf.sections))
+	for name := range cf.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Settings returns the settings of the named problem: its section, overridden by
+// PROTOHACKERS_SET_<NAME>_<KEY> environment variables, e.g.
+// PROTOHACKERS_SET_SPEED_DISPATCH_ATTEMPTS. A nil ConfigFile only has the environment variables.
+func (cf *ConfigFile) Settings(problem string) Settings {
+	s := make(Settings)
+	if cf != nil {
+		for key, value := range cf.sections[problem] {
+			s[key] = value
+		}
+	}
+	prefix := settingsEnvPrefix + strings.ToUpper(strings.ReplaceAll(problem, "-", "_")) + "_"
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if key, ok := strings.CutPrefix(name, prefix); ok && key != "" {
+			s[strings.ToLower(key)] = value
+		}
+	}
+	return s
+}
+
+// Decode sets the fields of the struct v points to from the settings, by their toml tags, then
+// validates it if it has a Validate method. Fields without a setting keep their value, so v
+// holds the defaults beforehand.
+func (s Settings) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode settings into %T", v)
+	}
+	rv = rv.Elem()
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < rv.NumField(); i++ {
+		if key := rv.Type().Field(i).Tag.Get("toml"); key != "" {
+			fields[key] = rv.Field(i)
+		}
+	}
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %s", key)
+		}
+		if err := setField(field, s[key]); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if validator, ok := v.(interface{ Validate() error }); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+func setField(field reflect.Value, text string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", text)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", text)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 0, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", text)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 0, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", text)
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
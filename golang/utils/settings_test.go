@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	cf, err := ParseConfigFile(strings.NewReader(`
+# Tunables of every problem
+[speed]
+queue = 8_192   # tickets
+delay = "1ms"
+enabled = true
+
+[ mitm ]
+upstream = "chat.example.com:16963"
+greeting = "say \"hi\"\t#1"
+path = 'C:\chat' # literal
+ratio = 0.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.Sections(); !reflect.DeepEqual(got, []string{"mitm", "speed"}) {
+		t.Errorf("unexpected sections %v", got)
+	}
+	for _, tc := range []struct {
+		section string
+		want    Settings
+	}{
+		{"speed", Settings{"queue": "8_192", "delay": "1ms", "enabled": "true"}},
+		{"mitm", Settings{"upstream": "chat.example.com:16963", "greeting": "say \"hi\"\t#1", "path": `C:\chat`, "ratio": "0.5"}},
+		{"echo", Settings{}},
+	} {
+		if got := cf.Settings(tc.section); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("[%s]: expected %q, got %q", tc.section, tc.want, got)
+		}
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		file, err string
+	}{
+		{"queue = 1", "line 1: setting queue outside of a [problem] section"},
+		{"[speed]\nqueue = 1\nqueue = 2", "line 3: queue set twice"},
+		{"[speed]\n[speed]", "line 2: section [speed] defined twice"},
+		{"[speed", "line 1: unterminated section header"},
+		{"[sp eed]", "line 1: invalid section name"},
+		{"[speed.roads]", "line 1: nested and quoted table names are not supported"},
+		{`["speed"]`, "line 1: nested and quoted table names are not supported"},
+		{"[[speed]]", "line 1: arrays of tables are not supported"},
+		{"[speed]\nqueue", "line 2: expected key = value"},
+		{"[speed]\nqueue =", "line 2: no value for queue"},
+		{"[speed]\nspeed.queue = 1", "line 2: dotted keys are not supported"},
+		{"[speed]\n\"queue\" = 1", "line 2: quoted keys are not supported"},
+		{"[speed]\n'queue' = 1", "line 2: quoted keys are not supported"},
+		{"[speed]\nque ue = 1", "line 2: invalid key"},
+		{"[speed]\ndelay = 1ms", "line 2: invalid value \"1ms\" for delay, strings must be quoted"},
+		{"[speed]\nenabled = True", "line 2: invalid value \"True\""},
+		{"[speed]\ndelay = \"1ms", "line 2: unterminated string for delay"},
+		{"[speed]\ndelay = '1ms", "line 2: unterminated string for delay"},
+		{"[speed]\ndelay = \"1ms\" 2", "line 2: unexpected \"2\""},
+		{"[speed]\nroads = [1, 2]", "line 2: arrays and inline tables are not supported"},
+		{"[speed]\nroads = { a = 1 }", "line 2: arrays and inline tables are not supported"},
+		{"[speed]\ndelay = \"\"\"1ms\"\"\"", "line 2: multi-line strings are not supported"},
+		{"[speed]\ndelay = '''\n1ms'''", "line 2: multi-line strings are not supported"},
+		{"[speed]\nsince = 1979-05-27", "line 2: dates are not supported"},
+		{"[speed]\nsince = 1979-05-27T07:32:00Z", "line 2: dates are not supported"},
+	} {
+		_, err := ParseConfigFile(strings.NewReader(tc.file))
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%q: expected error %q, got %v", tc.file, tc.err, err)
+		}
+	}
+}
+
+type testSettings struct {
+	Queue   int           `toml:"queue"`
+	Delay   time.Duration `toml:"delay"`
+	Enabled bool          `toml:"enabled"`
+	Name    string        `toml:"name"`
+	Ratio   float64       `toml:"ratio"`
+	Port    uint16        `toml:"port"`
+}
+
+func (s testSettings) Validate() error {
+	if s.Queue <= 0 {
+		return errors.New("queue must be positive")
+	}
+	return nil
+}
+
+func TestSettingsDecode(t *testing.T) {
+	defaults := testSettings{Queue: 8192, Delay: time.Millisecond, Name: "default"}
+	for _, tc := range []struct {
+		settings Settings
+		want     testSettings
+		err      string
+	}{
+		{Settings{}, defaults, ""},
+		{
+			Settings{"queue": "8_192", "delay": "2s", "enabled": "true", "name": "x", "ratio": "0.5", "port": "0x10"},
+			testSettings{8192, 2 * time.Second, true, "x", 0.5, 16},
+			"",
+		},
+		{Settings{"queue": "0"}, testSettings{}, "queue must be positive"},
+		{Settings{"queue": "many"}, testSettings{}, `queue: invalid integer "many"`},
+		{Settings{"delay": "1"}, testSettings{}, `delay: invalid duration "1"`},
+		{Settings{"port": "70000"}, testSettings{}, `port: invalid unsigned integer "70000"`},
+		{Settings{"color": "red"}, testSettings{}, "unknown setting color"},
+	} {
+		got := defaults
+		err := tc.settings.Decode(&got)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%v: expected error %q, got %v", tc.settings, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tc.settings, err)
+		} else if got != tc.want {
+			t.Errorf("%v: expected %+v, got %+v", tc.settings, tc.want, got)
+		}
+	}
+}
+
+func TestSettingsFromEnvironment(t *testing.T) {
+	cf, err := ParseConfigFile(strings.NewReader("[budget-chat]\nqueue = 1\ndelay = \"1ms\""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROTOHACKERS_SET_BUDGET_CHAT_QUEUE", "2")
+	t.Setenv("PROTOHACKERS_SET_BUDGET_CHAT_NAME", "env")
+	// Not settings, even for problems named like them
+	t.Setenv("PROTOHACKERS_BUDGET_CHAT_DELAY", "2ms")
+	t.Setenv(readyFDEnv, "3")
+	want := Settings{"queue": "2", "delay": "1ms", "name": "env"}
+	if got := cf.Settings("budget-chat"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	// Without a file
+	var none *ConfigFile
+	if got := none.Settings("budget-chat"); !reflect.DeepEqual(got, Settings{"queue": "2", "name": "env"}) {
+		t.Errorf("unexpected settings without a file %q", got)
+	}
+	if got := none.Settings("ready"); len(got) != 0 {
+		t.Errorf("unexpected settings %q from %s", got, readyFDEnv)
+	}
+}
+
+func FuzzParseConfigFile(f *testing.F) {
+	f.Add("[speed]\nqueue = 8_192 # tickets\ndelay = \"1ms\"\nname = 'x'\nenabled = true\n")
+	f.Add("[mitm]\nupstream = \"a\\\"b\\u00e9\"")
+	f.Fuzz(func(t *testing.T, file string) {
+		cf, err := ParseConfigFile(strings.NewReader(file))
+		if err != nil {
+			return
+		}
+		for _, name := range cf.Sections() {
+			if !isBareKey(name) {
+				t.Fatalf("section %q parsed from %q", name, file)
+			}
+		}
+	})
+}
@@ -1,84 +1,399 @@
 package utils
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 )
 
 type TCPServer struct {
-	wg         sync.WaitGroup
-	listener   net.Listener
-	shutdown   chan struct{}
-	handler    func(net.Conn)
-	connection chan net.Conn
+	name         string // problem name used to label metrics
+	wg           sync.WaitGroup
+	listener     net.Listener
+	shutdown     chan struct{}
+	closeOnce    sync.Once
+	handler      HandlerFunc
+	connection   chan net.Conn
+	nextID       uint64             // last connection ID handed out
+	ctx          context.Context    // cancelled when the server starts shutting down
+	cancel       context.CancelFunc // signals handlers to finish up
+	mu           sync.Mutex
+	conns        map[net.Conn]*liveConn // live connections
+	connWG       sync.WaitGroup         // one per live connection
+	acceptErr    func(error)            // told about persistent accept errors
+	limiter      *limiter
+	rejectBanner string      // sent to connections over a limit
+	tlsConfig    *tls.Config // nil serves plaintext
+	proxyMode    ProxyMode
+	recordDir    string // record each session to a file in this directory, if set
+	trace        bool   // log every frame with the problem's decoder
+	logger       *slog.Logger
+	idleTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
-func NewTCPServer(address string, handler func(net.Conn)) (*TCPServer, error) {
-	listener, err := net.Listen("tcp", address)
+// ShutdownReport tells how the connections still open at shutdown ended.
+type ShutdownReport struct {
+	Drained int // closed by their handler before the deadline
+	Killed  int // force-closed once the deadline passed
+}
+
+func NewTCPServer(address string, handler HandlerFunc, opts ...TCPOption) (*TCPServer, error) {
+	listener, err := Listen(address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on address %s: %w", address, err)
 	}
 
-	return &TCPServer{
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &TCPServer{
 		listener:   listener,
 		shutdown:   make(chan struct{}),
 		connection: make(chan net.Conn),
 		handler:    handler,
-	}, nil
+		ctx:        ctx,
+		cancel:     cancel,
+		conns:      make(map[net.Conn]*liveConn),
+		limiter:    newLimiter(),
+		logger:     slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.logger = s.logger.With("addr", listener.Addr().String())
+	if s.acceptErr == nil {
+		s.acceptErr = func(err error) {
+			s.logger.Error("stopped accepting connections", "err", err)
+		}
+	}
+	return s, nil
 }
 
 func (s *TCPServer) acceptConnections() {
 	defer s.wg.Done()
+	// Back off on temporary errors the way net/http does, so running out of file descriptors
+	// doesn't turn into a busy loop
+	var delay time.Duration
 	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if !isTemporary(err) {
+				s.acceptErr(err)
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else {
+				delay *= 2
+			}
+			if delay > time.Second {
+				delay = time.Second
+			}
+			s.logger.Warn("accept error", "err", err, "retry_in", delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-s.shutdown:
+				return
+			}
+		}
+		delay = 0
 		select {
+		case s.connection <- conn:
 		case <-s.shutdown:
+			conn.Close()
 			return
-		default:
-			conn, err := s.listener.Accept()
-			if err != nil {
-				continue
-			}
-			s.connection <- conn
 		}
 	}
 }
 
-func (s *TCPServer) Start() {
+// isTemporary tells whether an accept error is worth retrying.
+func isTemporary(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EINTR} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TCPServer) Start() error {
 	s.wg.Add(2)
 	go s.acceptConnections()
 	go s.handleConnections()
+	return nil
 }
 
 func (s *TCPServer) handleConnections() {
 	defer s.wg.Done()
-	// TODO select on shutdown channel
 	for {
 		select {
 		case <-s.shutdown:
 			return
 		case conn := <-s.connection:
-			go s.handler(conn)
+			s.nextID++
+			info := ConnInfo{
+				ID:         s.nextID,
+				Problem:    s.name,
+				RemoteAddr: conn.RemoteAddr(),
+				AcceptedAt: time.Now(),
+			}
+			connsAccepted.With(s.name).Inc()
+			live := s.track(conn, info)
+			go s.serve(conn, live)
 		}
 	}
 }
 
-func (s *TCPServer) Stop() {
-	close(s.shutdown)
-	s.listener.Close()
+func (s *TCPServer) serve(conn net.Conn, live *liveConn) {
+	defer s.untrack(conn)
+	info := live.info
+	if s.proxyMode != ProxyOff {
+		if s.limiter.enabled() {
+			// Reading the header can take a while, so it happens in a slot of its own. The
+			// per-IP limit waits for the client's address the header gives.
+			release, err := s.limiter.reserve(s.ctx)
+			if err != nil {
+				logger := s.logger.With("conn", info.ID, "remote", info.RemoteAddr.String())
+				s.reject(ContextWithLogger(s.ctx, logger), conn, err)
+				return
+			}
+			defer release()
+		}
+		// The header has to be read before anything looks at the remote address
+		pc, err := readProxyHeader(conn, s.proxyMode)
+		if err != nil {
+			s.logger.Debug("bad PROXY protocol header", "conn", info.ID, "remote", info.RemoteAddr.String(), "err", err)
+			connsRejected.With(s.name, "proxy_header").Inc()
+			conn.Close()
+			return
+		}
+		info.RemoteAddr = pc.RemoteAddr()
+		s.mu.Lock()
+		live.info = info
+		s.mu.Unlock()
+		conn = pc
+	}
+	logger := s.logger.With("conn", info.ID, "remote", info.RemoteAddr.String())
+	ctx := ContextWithLogger(withConnInfo(s.ctx, info), logger)
+
+	if s.limiter.enabled() {
+		acquire := s.limiter.acquire
+		if s.proxyMode != ProxyOff {
+			acquire = s.limiter.claim
+		}
+		release, err := acquire(ctx, conn.RemoteAddr())
+		if err != nil {
+			s.reject(ctx, conn, err)
+			return
+		}
+		defer release()
+	}
+
+	if s.tlsConfig != nil {
+		tlsConn := tls.Server(conn, s.tlsConfig)
+		hctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+		err := tlsConn.HandshakeContext(hctx)
+		cancel()
+		if err != nil {
+			LoggerFromContext(ctx).Debug("TLS handshake failed", "err", err)
+			connsRejected.With(s.name, "tls_handshake").Inc()
+			conn.Close()
+			return
+		}
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
+		ctx = withConnInfo(ctx, info)
+		conn = tlsConn
+	}
+
+	active := connsActive.With(s.name)
+	active.Inc()
+	defer active.Dec()
+	start := time.Now()
+	defer func() {
+		handlerDuration.With(s.name).Observe(time.Since(start).Seconds())
+	}()
+
+	if s.recordDir != "" {
+		rec, err := newRecorder(s.recordDir, info)
+		if err != nil {
+			logger.Warn("cannot record session", "err", err)
+		} else {
+			rc := &recordingConn{Conn: conn, rec: rec}
+			defer rc.finish()
+			conn = rc
+		}
+	}
+
+	if s.trace {
+		conn = newTracingConn(conn, s.name, logger)
+	}
+
+	conn = &countingConn{conn, live, bytesReceived.With(s.name), bytesSent.With(s.name)}
+	if s.idleTimeout > 0 || s.readTimeout > 0 || s.writeTimeout > 0 {
+		conn = newDeadlineConn(conn, s.idleTimeout, s.readTimeout, s.writeTimeout)
+	}
+	defer recoverHandler(ctx, s.name, func() { conn.Close() })
+	s.handler(ctx, conn)
+}
+
+func (s *TCPServer) reject(ctx context.Context, conn net.Conn, reason error) {
+	defer conn.Close()
+	LoggerFromContext(ctx).Debug("connection rejected", "reason", reason)
+	if reason == errServerFull {
+		connsRejected.With(s.name, "server_full").Inc()
+	} else {
+		connsRejected.With(s.name, "ip_full").Inc()
+	}
+	// A plaintext banner would only garble a TLS client's handshake
+	if s.rejectBanner != "" && s.tlsConfig == nil {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte(s.rejectBanner))
+	}
+}
+
+// Rejected returns how many connections the limits have turned away so far.
+func (s *TCPServer) Rejected() LimitStats {
+	return s.limiter.stats()
+}
+
+func (s *TCPServer) track(conn net.Conn, info ConnInfo) *liveConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	live := &liveConn{info: info}
+	s.conns[conn] = live
+	s.connWG.Add(1)
+	return live
+}
+
+// Conns lists the connections currently open.
+func (s *TCPServer) Conns() []ConnStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]ConnStatus, 0, len(s.conns))
+	for _, live := range s.conns {
+		conns = append(conns, live.status())
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+// Kick closes the connection with the given ID. It returns false if there is no such connection.
+func (s *TCPServer) Kick(id uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, live := range s.conns {
+		if live.info.ID == id {
+			s.logger.Info("kicking connection", "conn", id, "remote", live.info.RemoteAddr.String())
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TCPServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; ok {
+		delete(s.conns, conn)
+		s.connWG.Done()
+	}
+}
+
+// Addr returns the address the server is listening on.
+func (s *TCPServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting connections and cancels the handlers' context, then waits for the live
+// connections to drain until ctx is done. Connections still open after that are force-closed.
+func (s *TCPServer) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	s.stopAccepting()
+
+	s.mu.Lock()
+	open := len(s.conns)
+	s.mu.Unlock()
+
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return ShutdownReport{Drained: open}, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	killed := len(s.conns)
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return ShutdownReport{Drained: open - killed, Killed: killed}, ctx.Err()
+}
+
+// stopAccepting closes the listener and waits for the accept loop to end.
+func (s *TCPServer) stopAccepting() {
+	s.closeOnce.Do(func() {
+		close(s.shutdown)
+		s.listener.Close()
+	})
+	s.wg.Wait()
+}
+
+// Drain stops accepting connections and waits for the open ones to be closed by their clients or
+// handlers, without cancelling the handlers' context. Connections still open once ctx is done
+// are shut down like Stop does with an expired deadline.
+func (s *TCPServer) Drain(ctx context.Context) error {
+	s.stopAccepting()
+	s.mu.Lock()
+	open := len(s.conns)
+	s.mu.Unlock()
+	s.logger.Info("draining connections", "open", open)
+
 	done := make(chan struct{})
 	go func() {
-		s.wg.Wait()
+		s.connWG.Wait()
 		close(done)
 	}()
 	select {
 	case <-done:
-		return
-	case <-time.After(time.Second):
-		fmt.Println("Timed out waiting for connections to finish.")
-		return
+		s.cancel()
+		s.logger.Info("server drained", "drained", open)
+		return nil
+	case <-ctx.Done():
+	}
+	return s.Stop(ctx)
+}
+
+// Stop shuts the server down like Shutdown and logs how the open connections ended.
+func (s *TCPServer) Stop(ctx context.Context) error {
+	report, err := s.Shutdown(ctx)
+	s.logger.Info("server stopped", "drained", report.Drained, "killed", report.Killed)
+	if err != nil {
+		return fmt.Errorf("timed out waiting for connections to finish: %w", err)
 	}
+	return nil
 }
 
 const LISTENADDRESS string = "0.0.0.0:4242"
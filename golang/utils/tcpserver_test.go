@@ -0,0 +1,348 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func echo(conn net.Conn) {
+	defer conn.Close()
+	io.Copy(conn, conn)
+}
+
+func TestShutdownDrainsAndKills(t *testing.T) {
+	// Handlers that watch the context finish on shutdown, the others have to be killed
+	server, err := NewTCPServer("127.0.0.1:0", func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+		buf := make([]byte, 1)
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+		if buf[0] == 'd' {
+			<-ctx.Done()
+			return
+		}
+		io.Copy(io.Discard, conn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	for _, role := range []string{"d", "d", "k"} {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(role)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Give the handlers time to read the role byte
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	report, err := server.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if report.Drained != 2 || report.Killed != 1 {
+		t.Errorf("expected 2 drained and 1 killed, got %+v", report)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	release := make(chan struct{})
+	server, err := NewTCPServer("127.0.0.1:0", Handler(func(conn net.Conn) {
+		defer conn.Close()
+		conn.Write([]byte("ok\n"))
+		<-release
+	}), WithMaxConnsPerIP(2), WithRejectBanner("busy\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+	defer close(release)
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		if i < 2 {
+			// Wait until the connection is being served so it holds its slot
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, make([]byte, 3)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Only the third connection is over the limit, it gets the banner and EOF
+	conns[2].SetReadDeadline(time.Now().Add(time.Second))
+	banner, err := io.ReadAll(conns[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(banner) != "busy\n" {
+		t.Errorf("expected banner %q, but got %q", "busy\n", banner)
+	}
+	if stats := server.Rejected(); stats.PerIP != 1 || stats.Global != 0 {
+		t.Errorf("expected 1 per-IP rejection, got %+v", stats)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0", Handler(func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}), WithIdleTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Activity keeps the connection open past the idle timeout
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := conn.Write([]byte{'x'}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Silence gets it closed
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF from idle connection, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("idle connection closed after %v", elapsed)
+	}
+}
+
+func TestHandlerReadDeadline(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		server, handler time.Duration
+		atLeast, atMost time.Duration
+	}{
+		{"shorter than the server's", 2 * time.Second, 100 * time.Millisecond, 100 * time.Millisecond, time.Second},
+		{"longer than the server's", 100 * time.Millisecond, 5 * time.Second, 100 * time.Millisecond, time.Second},
+	} {
+		elapsed := make(chan time.Duration, 1)
+		server, err := NewTCPServer("127.0.0.1:0", Handler(func(conn net.Conn) {
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(tc.handler))
+			start := time.Now()
+			_, err := conn.Read(make([]byte, 1))
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Errorf("%s: expected a timeout, got %v", tc.name, err)
+			}
+			elapsed <- time.Since(start)
+		}), WithReadTimeout(tc.server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Start()
+
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case d := <-elapsed:
+			if d < tc.atLeast || d > tc.atMost {
+				t.Errorf("%s: read timed out after %v", tc.name, d)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: read did not time out", tc.name)
+		}
+		conn.Close()
+		server.Stop(context.Background())
+	}
+}
+
+func TestDrainKeepsServingOpenConnections(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0", func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// Make sure the connection was accepted before draining
+	conn.Write([]byte{'a'})
+	io.ReadFull(conn, make([]byte, 1))
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- server.Drain(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if c, err := net.DialTimeout("tcp", server.Addr().String(), time.Second); err == nil {
+		c.Close()
+		t.Error("expected new connections to be refused while draining")
+	}
+	// The open connection is still served, without its context being cancelled
+	if _, err := conn.Write([]byte{'b'}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(conn, buf); err != nil || buf[0] != 'b' {
+		t.Fatalf("expected echo while draining, got %q, %v", buf, err)
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain finished with a connection open")
+	default:
+	}
+
+	conn.Close()
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not finish after the connection closed")
+	}
+}
+
+func TestHandlerPanicClosesOnlyItsConnection(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0", Handler(func(conn net.Conn) {
+		defer conn.Close()
+		buf := make([]byte, 1)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+			if buf[0] == 'p' {
+				panic("boom")
+			}
+			conn.Write(buf)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	bystander := dial()
+	defer bystander.Close()
+	bystander.Write([]byte{'a'})
+	io.ReadFull(bystander, make([]byte, 1))
+
+	before := handlerPanics.With("").Value()
+	culprit := dial()
+	defer culprit.Close()
+	culprit.Write([]byte{'p'})
+	if _, err := culprit.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the panicking connection to be closed, got %v", err)
+	}
+	if got := handlerPanics.With("").Value() - before; got != 1 {
+		t.Errorf("expected 1 panic counted, got %v", got)
+	}
+
+	// The process and the other connection carry on
+	bystander.Write([]byte{'b'})
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(bystander, buf); err != nil || buf[0] != 'b' {
+		t.Errorf("expected the other connection to keep working, got %q, %v", buf, err)
+	}
+}
+
+func TestConnsAndKick(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0", Handler(echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The echo is counted once its write returns, which may be after the client read it
+	var c ConnStatus
+	for deadline := time.Now().Add(2 * time.Second); c.BytesOut != 5; time.Sleep(10 * time.Millisecond) {
+		conns := server.Conns()
+		if len(conns) != 1 {
+			t.Fatalf("expected one connection, got %+v", conns)
+		}
+		if c = conns[0]; time.Now().After(deadline) {
+			t.Fatalf("expected 5 bytes echoed, got %+v", c)
+		}
+	}
+	if c.RemoteAddr != conn.LocalAddr().String() || c.BytesIn != 5 {
+		t.Errorf("expected 5 bytes from %s, got %+v", conn.LocalAddr(), c)
+	}
+
+	if server.Kick(c.ID + 1) {
+		t.Errorf("kicked unknown connection %d", c.ID+1)
+	}
+	if !server.Kick(c.ID) {
+		t.Fatalf("could not kick connection %d", c.ID)
+	}
+	if _, err := conn.Read(buf); err == nil {
+		t.Errorf("expected the kicked connection to be closed")
+	}
+	// The handler is gone once the connection no longer shows
+	for deadline := time.Now().Add(2 * time.Second); len(server.Conns()) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("kicked connection still listed: %+v", server.Conns())
+		}
+	}
+	if server.Kick(c.ID) {
+		t.Errorf("kicked connection %d twice", c.ID)
+	}
+}
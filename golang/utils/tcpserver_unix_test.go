@@ -0,0 +1,88 @@
+//go:build unix
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAcceptRecoversFromFDExhaustion(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0", Handler(func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}), WithAcceptErrorHandler(func(err error) {
+		t.Errorf("unexpected persistent accept error: %v", err)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skip("cannot read fd limit:", err)
+	}
+	lowered := limit
+	lowered.Cur = 256
+	if lowered.Cur > limit.Cur {
+		lowered.Cur = limit.Cur
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skip("cannot lower fd limit:", err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+
+	// Use up every descriptor, then free one for the client side of the connection
+	var filler []*os.File
+	defer func() {
+		for _, f := range filler {
+			f.Close()
+		}
+	}()
+	for {
+		f, err := os.Open(os.DevNull)
+		if errors.Is(err, syscall.EMFILE) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		filler = append(filler, f)
+	}
+	filler[len(filler)-1].Close()
+	filler = filler[:len(filler)-1]
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The server can't accept while the table is full; let it retry a few times
+	time.Sleep(100 * time.Millisecond)
+	for _, f := range filler {
+		f.Close()
+	}
+	filler = nil
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	msg := "still alive"
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	actual := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, actual); err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != msg {
+		t.Errorf("expected %q, but got %q", msg, actual)
+	}
+}
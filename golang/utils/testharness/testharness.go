@@ -0,0 +1,206 @@
+// Package testharness runs servers on ephemeral loopback ports and talks to them the way the
+// problems' clients do, failing the test on errors and timeouts instead of returning them.
+package testharness
+
+import (
+	"context"
+	"net"
+	"protohackers/utils"
+	"protohackers/utils/conformance"
+	"time"
+)
+
+// Timeout is how long a client waits for the server before failing the test.
+const Timeout = conformance.Timeout
+
+// T is the part of testing.TB the harness uses.
+type T interface {
+	Helper()
+	Fatalf(format string, args ...any)
+	Cleanup(func())
+}
+
+// Server is a server listening on an ephemeral port until the test ends.
+type Server struct {
+	utils.Server
+	Addr string // Address clients dial, e.g. 127.0.0.1:41234
+}
+
+// Config returns the configuration of the named problem listening on an ephemeral loopback port.
+func Config(name string) utils.Config {
+	cfg := utils.DefaultConfig()
+	cfg.Name = name
+	cfg.Host, cfg.Port = "127.0.0.1", 0
+	return cfg
+}
+
+// Start runs the server of the named problem made by newServer on an ephemeral port.
+func Start(t T, name string, newServer func(utils.Config) (utils.Server, error)) *Server {
+	t.Helper()
+	return StartConfig(t, Config(name), newServer)
+}
+
+// StartConfig runs the server made by newServer from cfg. newServer must return a server with an
+// Addr method, like TCPServer and UDPServer.
+func StartConfig(t T, cfg utils.Config, newServer func(utils.Config) (utils.Server, error)) *Server {
+	t.Helper()
+	srv, err := newServer(cfg)
+	if err != nil {
+		t.Fatalf("cannot create %s server: %v", cfg.Name, err)
+	}
+	return start(t, srv)
+}
+
+// StartHandler serves handler over TCP on an ephemeral port.
+func StartHandler(t T, handler utils.HandlerFunc, opts ...utils.TCPOption) *Server {
+	t.Helper()
+	srv, err := utils.NewTCPServer("127.0.0.1:0", handler, opts...)
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+	return start(t, srv)
+}
+
+func start(t T, srv utils.Server) *Server {
+	t.Helper()
+	addressed, ok := srv.(interface{ Addr() net.Addr })
+	if !ok {
+		t.Fatalf("%T does not report its address", srv)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("cannot start server: %v", err)
+	}
+	// Registered before any client, so it runs after their cleanups have closed them
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Stop(ctx)
+	})
+	return &Server{srv, addressed.Addr().String()}
+}
+
+// Conn is a client connection failing the test whenever the server doesn't answer as expected.
+// Its methods must be called from the test's goroutine.
+type Conn struct {
+	t T
+	c *conformance.Conn
+}
+
+// Dial connects to addr, closing the connection when the test ends.
+func Dial(t T, network, addr string) *Conn {
+	t.Helper()
+	c, err := conformance.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &Conn{t: t, c: c}
+}
+
+// check fails the test on err.
+func (c *Conn) check(err error) {
+	c.t.Helper()
+	if err != nil {
+		c.t.Fatalf("%v", err)
+	}
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.c.NetConn()
+}
+
+// Write sends b, or a datagram holding b over UDP.
+func (c *Conn) Write(b []byte) {
+	c.t.Helper()
+	c.check(c.c.Write(b))
+}
+
+// WriteLine sends line followed by a newline.
+func (c *Conn) WriteLine(line string) {
+	c.t.Helper()
+	c.check(c.c.WriteLine(line))
+}
+
+// ReadLine returns the next line without its newline.
+func (c *Conn) ReadLine() string {
+	c.t.Helper()
+	line, err := c.c.ReadLine()
+	c.check(err)
+	return line
+}
+
+// ExpectLine fails the test unless the next line is want.
+func (c *Conn) ExpectLine(want string) {
+	c.t.Helper()
+	c.check(c.c.ExpectLine(want))
+}
+
+// ReadN returns the next n bytes.
+func (c *Conn) ReadN(n int) []byte {
+	c.t.Helper()
+	buf, err := c.c.ReadN(n)
+	c.check(err)
+	return buf
+}
+
+// Expect fails the test unless the next bytes are want.
+func (c *Conn) Expect(want []byte) {
+	c.t.Helper()
+	c.check(c.c.Expect(want))
+}
+
+// ReadFrame returns the next frame the server sent, split and described by d the way -trace
+// does, e.g. a problem's registered decoder.
+func (c *Conn) ReadFrame(d utils.Decoder) ([]byte, string) {
+	c.t.Helper()
+	frame, desc, err := c.c.ReadFrame(d)
+	c.check(err)
+	return frame, desc
+}
+
+// ExpectFrame fails the test unless d describes the next frame as want.
+func (c *Conn) ExpectFrame(d utils.Decoder, want string) {
+	c.t.Helper()
+	c.check(c.c.ExpectFrame(d, want))
+}
+
+// ReadDatagram returns the next datagram received over UDP.
+func (c *Conn) ReadDatagram() []byte {
+	c.t.Helper()
+	buf, err := c.c.ReadDatagram()
+	c.check(err)
+	return buf
+}
+
+// ExpectSilence fails the test if the server sends anything within d.
+func (c *Conn) ExpectSilence(d time.Duration) {
+	c.t.Helper()
+	c.check(c.c.ExpectSilence(d))
+}
+
+// ReadAll returns everything the server sends until it closes the connection.
+func (c *Conn) ReadAll() []byte {
+	c.t.Helper()
+	data, err := c.c.ReadAll()
+	c.check(err)
+	return data
+}
+
+// ExpectClosed fails the test unless the server closes the connection, without sending
+// anything more first.
+func (c *Conn) ExpectClosed() {
+	c.t.Helper()
+	c.check(c.c.ExpectClosed())
+}
+
+// CloseWrite shuts down the sending side of a TCP connection, telling the server it got all.
+func (c *Conn) CloseWrite() {
+	c.t.Helper()
+	c.check(c.c.CloseWrite())
+}
+
+// Close closes the connection.
+func (c *Conn) Close() {
+	c.c.Close()
+}
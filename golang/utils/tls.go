@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds how long a client may take to complete the TLS handshake.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// LoadTLSConfig builds the server TLS configuration from a certificate and key file, or from a
+// freshly generated self-signed certificate when selfSigned is set. With a clientCA file, clients
+// presenting a certificate must have it signed by one of its CAs; see ClientVerified.
+// It returns nil when neither a certificate nor selfSigned is given.
+func LoadTLSConfig(certFile, keyFile, clientCAFile string, selfSigned bool) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case selfSigned && (certFile != "" || keyFile != ""):
+		return nil, errors.New("a self-signed certificate and a certificate file are mutually exclusive")
+	case selfSigned:
+		cert, err = SelfSignedCertificate()
+	case certFile != "" && keyFile != "":
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	case certFile != "" || keyFile != "":
+		return nil, errors.New("both a TLS certificate and a key are needed")
+	case clientCAFile != "":
+		return nil, errors.New("client certificate verification needs TLS enabled")
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		// Clients without a certificate may still connect; services decide which roles need one
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// SelfSignedCertificate generates an in-memory certificate for localhost, meant for development.
+func SelfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"protohackers development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// ClientVerified tells whether the connection in ctx presented a client certificate that was
+// verified against the configured client CAs.
+func ClientVerified(ctx context.Context) bool {
+	info, ok := ConnInfoFromContext(ctx)
+	return ok && info.TLS != nil && len(info.TLS.VerifiedChains) > 0
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSServer(t *testing.T) {
+	cfg, err := LoadTLSConfig("", "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTCPServer("127.0.0.1:0", func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+		info, _ := ConnInfoFromContext(ctx)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "%s tls=%v verified=%v\n", line[:len(line)-1], info.TLS != nil, ClientVerified(ctx))
+	}, WithTLSConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop(context.Background())
+
+	conn, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello tls=true verified=false\n"; reply != want {
+		t.Errorf("expected %q, got %q", want, reply)
+	}
+
+	// A plaintext client never gets to the handler
+	plain, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	plain.SetDeadline(time.Now().Add(5 * time.Second))
+	plain.Write([]byte("hello\n"))
+	buf := make([]byte, 64)
+	n, _ := plain.Read(buf)
+	if n > 0 && buf[0] != 0x15 { // anything but a TLS alert record
+		t.Errorf("plaintext client got %q", buf[:n])
+	}
+}
+
+func TestLoadTLSConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		cert, key, ca string
+		selfSigned    bool
+	}{
+		{cert: "cert.pem"},
+		{key: "key.pem"},
+		{cert: "cert.pem", key: "key.pem", selfSigned: true},
+		{ca: "ca.pem"},
+	} {
+		if _, err := LoadTLSConfig(tc.cert, tc.key, tc.ca, tc.selfSigned); err == nil {
+			t.Errorf("expected an error for %+v", tc)
+		}
+	}
+	if cfg, err := LoadTLSConfig("", "", "", false); cfg != nil || err != nil {
+		t.Errorf("expected no TLS config, got %v, %v", cfg, err)
+	}
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// Decoder splits a problem's byte stream into frames and describes them for -trace.
+type Decoder interface {
+	// Decode returns the length of the first frame in buf and a description of it, or 0 if buf
+	// doesn't hold a complete frame yet. inbound is true for data sent by the client.
+	Decode(buf []byte, inbound bool) (n int, desc string)
+}
+
+// DecoderFunc adapts a function to the Decoder interface.
+type DecoderFunc func(buf []byte, inbound bool) (int, string)
+
+func (f DecoderFunc) Decode(buf []byte, inbound bool) (int, string) {
+	return f(buf, inbound)
+}
+
+var (
+	decodersMu sync.Mutex
+	decoders   = make(map[string]Decoder)
+)
+
+// RegisterDecoder sets the decoder traced connections of the named problem are logged with.
+// Problems without one are traced as raw chunks.
+func RegisterDecoder(problem string, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[problem] = d
+}
+
+func lookupDecoder(problem string) Decoder {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if d, ok := decoders[problem]; ok {
+		return d
+	}
+	return RawDecoder
+}
+
+// RawDecoder traces whatever each read or write carried as one frame, quoted if it is text.
+var RawDecoder = DecoderFunc(func(buf []byte, _ bool) (int, string) {
+	return len(buf), quoteText(buf)
+})
+
+// LineDecoder traces newline terminated text protocols a line at a time.
+var LineDecoder = DecoderFunc(func(buf []byte, _ bool) (int, string) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return 0, ""
+	}
+	return i + 1, strconv.Quote(string(buf[:i]))
+})
+
+func quoteText(b []byte) string {
+	if !utf8.Valid(b) {
+		return ""
+	}
+	return strconv.Quote(string(b))
+}
+
+const (
+	// traceHexBytes is how many bytes of a frame are dumped in hex
+	traceHexBytes = 32
+	// maxTraceBuffer is how much undecoded data is kept before it is dumped raw
+	maxTraceBuffer = 64 << 10
+)
+
+// FormatFrame renders a frame the way the problem statements show sessions: direction, hex
+// bytes, then the decoded message, e.g. "<-- 49 00 00 30 39 00 00 00 65  I 12345 101".
+func FormatFrame(inbound bool, frame []byte, desc string) string {
+	dir := "-->"
+	if inbound {
+		dir = "<--"
+	}
+	hex := fmt.Sprintf("% x", frame)
+	if len(frame) > traceHexBytes {
+		hex = fmt.Sprintf("% x ... (%d bytes)", frame[:traceHexBytes], len(frame))
+	}
+	if desc == "" {
+		return dir + " " + hex
+	}
+	return dir + " " + hex + "  " + desc
+}
+
+// frameTracer buffers one direction of a connection and logs every complete frame.
+type frameTracer struct {
+	mu      sync.Mutex
+	inbound bool
+	decoder Decoder
+	logger  *slog.Logger
+	buf     []byte
+}
+
+func (t *frameTracer) add(p []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	for len(t.buf) > 0 {
+		n, desc := t.decoder.Decode(t.buf, t.inbound)
+		if n <= 0 {
+			if len(t.buf) < maxTraceBuffer {
+				return
+			}
+			n, desc = len(t.buf), "(no complete frame)"
+		}
+		if n > len(t.buf) {
+			n = len(t.buf)
+		}
+		t.logger.Info(FormatFrame(t.inbound, t.buf[:n], desc))
+		t.buf = append(t.buf[:0], t.buf[n:]...)
+	}
+}
+
+// tracingConn logs the frames read and written through the connection.
+type tracingConn struct {
+	net.Conn
+	in, out *frameTracer
+}
+
+func newTracingConn(conn net.Conn, problem string, logger *slog.Logger) *tracingConn {
+	d := lookupDecoder(problem)
+	return &tracingConn{
+		Conn: conn,
+		in:   &frameTracer{inbound: true, decoder: d, logger: logger},
+		out:  &frameTracer{inbound: false, decoder: d, logger: logger},
+	}
+}
+
+func (c *tracingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.in.add(p[:n])
+	}
+	return n, err
+}
+
+func (c *tracingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.out.add(p[:n])
+	}
+	return n, err
+}
+
+// traceDatagram logs a datagram as a single frame.
+func traceDatagram(logger *slog.Logger, problem string, pkt []byte, inbound bool) {
+	n, desc := lookupDecoder(problem).Decode(pkt, inbound)
+	if n != len(pkt) {
+		desc = ""
+	}
+	logger.Info(FormatFrame(inbound, pkt, desc))
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFrameTracer(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{
+		// Keep only the message
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key != slog.MessageKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	tracer := &frameTracer{inbound: true, decoder: LineDecoder, logger: logger}
+
+	// Frames split across reads are logged once complete, several in a read one by one
+	for _, chunk := range []string{"he", "llo\nwor", "ld\n", "bye\nagain\n", "partial"} {
+		tracer.add([]byte(chunk))
+	}
+	want := []string{
+		`msg="<-- 68 65 6c 6c 6f 0a  \"hello\""`,
+		`msg="<-- 77 6f 72 6c 64 0a  \"world\""`,
+		`msg="<-- 62 79 65 0a  \"bye\""`,
+		`msg="<-- 61 67 61 69 6e 0a  \"again\""`,
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestFormatFrame(t *testing.T) {
+	frame := []byte{0x49, 0x00, 0x00, 0x30, 0x39, 0x00, 0x00, 0x00, 0x65}
+	if got, want := FormatFrame(true, frame, "I 12345 101"), "<-- 49 00 00 30 39 00 00 00 65  I 12345 101"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got, want := FormatFrame(false, []byte{0, 0, 0, 0x65}, ""), "--> 00 00 00 65"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	long := FormatFrame(false, make([]byte, 100), "")
+	if !strings.HasSuffix(long, "... (100 bytes)") {
+		t.Errorf("expected long frames to be cut short, got %q", long)
+	}
+}
+
+func FuzzLineDecoder(f *testing.F) {
+	f.Add([]byte("Welcome to budgetchat! What shall I call you?\n"))
+	f.Add([]byte(`{"method":"isPrime","number":123}` + "\n{"))
+	f.Add([]byte("no newline"))
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		n, desc := LineDecoder.Decode(buf, true)
+		if n == 0 {
+			if bytes.IndexByte(buf, '\n') >= 0 {
+				t.Fatalf("no line found in %q", buf)
+			}
+			return
+		}
+		line, err := strconv.Unquote(desc)
+		if err != nil || buf[n-1] != '\n' || line != string(buf[:n-1]) || strings.IndexByte(line, '\n') >= 0 {
+			t.Fatalf("%q decoded as %d bytes: %s", buf, n, desc)
+		}
+	})
+}
@@ -1,36 +1,234 @@
 package utils
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
 	"net"
+	"runtime"
+	"sync"
+	"time"
 )
 
+// PacketHandlerFunc serves a single datagram received from a peer. reply sends a datagram back to
+// the peer from the address the request was received on. pkt is only valid until the handler returns.
+type PacketHandlerFunc func(ctx context.Context, pkt []byte, from net.Addr, reply func([]byte) error)
+
+// UDPOption configures optional UDPServer behaviour.
+type UDPOption func(*UDPServer)
+
+// WithWorkers sets how many datagrams are handled concurrently.
+func WithWorkers(n int) UDPOption {
+	return func(s *UDPServer) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+// WithMaxDatagramSize drops received datagrams bigger than n bytes and refuses to send them.
+func WithMaxDatagramSize(n int) UDPOption {
+	return func(s *UDPServer) {
+		if n > 0 {
+			s.maxSize = n
+		}
+	}
+}
+
+// WithPacketName sets the problem name the server's metrics are labelled with.
+func WithPacketName(name string) UDPOption {
+	return func(s *UDPServer) {
+		s.name = name
+	}
+}
+
+// WithPacketLogger sets the logger the server and its handler log to.
+func WithPacketLogger(logger *slog.Logger) UDPOption {
+	return func(s *UDPServer) {
+		s.logger = logger
+	}
+}
+
+// WithPacketTrace logs every datagram received and sent, decoded by the decoder registered for
+// the server's problem name.
+func WithPacketTrace(enabled bool) UDPOption {
+	return func(s *UDPServer) {
+		s.trace = enabled
+	}
+}
+
+// UDPServer reads datagrams from a socket and hands them to a pool of workers. Datagrams from the
+// same peer always go to the same worker, so each peer's requests are handled in order.
 type UDPServer struct {
-	handler  func(net.Conn)
-	shutdown chan struct{}
-	addr     *net.UDPAddr
+	name    string
+	conn    net.PacketConn
+	handler PacketHandlerFunc
+	workers int
+	maxSize int
+	logger  *slog.Logger
+	trace   bool
+	queues  []chan datagram
+	bufs    sync.Pool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	reader  sync.WaitGroup
+	pool    sync.WaitGroup
 }
 
-func NewUDPServer(address string, handler func(net.Conn)) (*UDPServer, error) {
-	addr, err := net.ResolveUDPAddr("udp", LISTENADDRESS)
+type datagram struct {
+	buf      *[]byte
+	n        int
+	from     net.Addr
+	received time.Time
+}
+
+// maxUDPPayload is the biggest payload an IPv4 UDP datagram can carry.
+const maxUDPPayload = 65507
+
+func NewUDPServer(address string, handler PacketHandlerFunc, opts ...UDPOption) (*UDPServer, error) {
+	conn, err := ListenPacket(address)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to listen on address %s: %w", address, err)
 	}
-	return &UDPServer{
-		shutdown: make(chan struct{}),
-		handler:  handler,
-		addr:     addr,
-	}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &UDPServer{
+		conn:    conn,
+		handler: handler,
+		workers: runtime.NumCPU(),
+		maxSize: maxUDPPayload,
+		logger:  slog.Default(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.logger = s.logger.With("addr", conn.LocalAddr().String())
+	s.bufs.New = func() any {
+		// One extra byte tells oversized datagrams apart from ones of exactly maxSize
+		buf := make([]byte, s.maxSize+1)
+		return &buf
+	}
+	return s, nil
 }
 
-func (s *UDPServer) Start() {
-	conn, err := net.ListenUDP("udp", s.addr)
-	if err != nil {
-		log.Fatal(err)
+// Addr returns the address the server is listening on.
+func (s *UDPServer) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *UDPServer) Start() error {
+	s.queues = make([]chan datagram, s.workers)
+	for i := range s.queues {
+		s.queues[i] = make(chan datagram, 64)
+		s.pool.Add(1)
+		go s.work(s.queues[i])
+	}
+	s.reader.Add(1)
+	go s.read()
+	return nil
+}
+
+func (s *UDPServer) read() {
+	defer s.reader.Done()
+	defer func() {
+		for _, q := range s.queues {
+			close(q)
+		}
+	}()
+	for {
+		buf := s.bufs.Get().(*[]byte)
+		n, from, err := s.conn.ReadFrom(*buf)
+		if err != nil {
+			s.bufs.Put(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("read error", "err", err)
+			continue
+		}
+		if n > s.maxSize {
+			s.bufs.Put(buf)
+			datagramsBig.With(s.name).Inc()
+			s.logger.Info("dropping oversized datagram", "remote", from.String(), "size", n, "max", s.maxSize)
+			continue
+		}
+		datagramsRecv.With(s.name).Inc()
+		bytesReceived.With(s.name).Add(float64(n))
+		s.queues[s.shard(from)] <- datagram{buf, n, from, time.Now()}
+	}
+}
+
+func (s *UDPServer) shard(addr net.Addr) int {
+	h := fnv.New32a()
+	h.Write([]byte(addr.String()))
+	return int(h.Sum32() % uint32(len(s.queues)))
+}
+
+func (s *UDPServer) work(queue chan datagram) {
+	defer s.pool.Done()
+	sent := bytesSent.With(s.name)
+	duration := handlerDuration.With(s.name)
+	for d := range queue {
+		from := d.from
+		reply := func(pkt []byte) error {
+			if len(pkt) > s.maxSize {
+				return fmt.Errorf("reply of %d bytes exceeds max datagram size %d", len(pkt), s.maxSize)
+			}
+			n, err := s.conn.WriteTo(pkt, from)
+			sent.Add(float64(n))
+			return err
+		}
+		info := ConnInfo{
+			Problem:    s.name,
+			RemoteAddr: from,
+			AcceptedAt: d.received,
+		}
+		ctx := ContextWithLogger(withConnInfo(s.ctx, info), s.logger.With("remote", from.String()))
+		s.handle(ctx, (*d.buf)[:d.n], from, reply)
+		duration.Observe(time.Since(d.received).Seconds())
+		s.bufs.Put(d.buf)
+	}
+}
+
+// handle runs the handler for one datagram, dropping it if the handler panics.
+func (s *UDPServer) handle(ctx context.Context, pkt []byte, from net.Addr, reply func([]byte) error) {
+	defer recoverHandler(ctx, s.name, nil)
+	if s.trace {
+		logger := LoggerFromContext(ctx)
+		traceDatagram(logger, s.name, pkt, true)
+		send := reply
+		reply = func(pkt []byte) error {
+			traceDatagram(logger, s.name, pkt, false)
+			return send(pkt)
+		}
 	}
-	s.handler(conn)
+	s.handler(ctx, pkt, from, reply)
 }
 
-func (s *UDPServer) Stop() {
-	close(s.shutdown)
+// Drain stops the server: datagrams carry no connection to finish, so new ones can go to another
+// process straight away.
+func (s *UDPServer) Drain(ctx context.Context) error {
+	return s.Stop(ctx)
+}
+
+// Stop closes the socket and waits for the workers to finish the datagrams already received,
+// or until ctx is done.
+func (s *UDPServer) Stop(ctx context.Context) error {
+	s.cancel()
+	err := s.conn.Close()
+	done := make(chan struct{})
+	go func() {
+		s.reader.Wait()
+		s.pool.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for workers to finish: %w", ctx.Err())
+	}
 }
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPServer(t *testing.T) {
+	server, err := NewUDPServer("127.0.0.1:0", func(ctx context.Context, pkt []byte, from net.Addr, reply func([]byte) error) {
+		reply([]byte(strings.ToUpper(string(pkt))))
+	}, WithWorkers(4), WithMaxDatagramSize(10), WithPacketName("udptest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+
+	conn, err := net.Dial("udp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// The oversized datagram is dropped, the others come back in order
+	for _, msg := range []string{"one", "this is too long", "two", "three"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 64)
+	for _, expected := range []string{"ONE", "TWO", "THREE"} {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != expected {
+			t.Errorf("expected %q, but got %q", expected, buf[:n])
+		}
+	}
+
+	if dropped := datagramsBig.With("udptest").Value(); dropped != 1 {
+		t.Errorf("expected 1 oversized datagram counted, got %v", dropped)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Stop(ctx); err != nil {
+		t.Errorf("stop: %v", err)
+	}
+}